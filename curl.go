@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// curlSkipHeaders are not repeated in generated commands: the client sets them itself from
+// the body it sends, or they only describe the proxied connection.
+var curlSkipHeaders = map[string]bool{
+	"Accept-Encoding":   true, // replaced by --compressed; HTTPie negotiates it on its own
+	"Connection":        true,
+	"Content-Encoding":  true, // the body is written decoded
+	"Content-Length":    true,
+	"Proxy-Connection":  true,
+	"Transfer-Encoding": true,
+}
+
+// commandContinuation separates the arguments of a generated command.
+const commandContinuation = " \\\n  "
+
+// curlCommand returns a curl command line that repeats the request of ex against the
+// upstream it was sent to. Options after the URL go on continuation lines. A binary body
+// is read from bodyFile; when bodyFile is empty the body is passed inline.
+func curlCommand(ex *exchange, bodyFile string) string {
+	r := ex.Request
+	head := "curl "
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodHead:
+		head += "--head "
+	default:
+		head += "-X " + shellQuote(r.Method) + " "
+	}
+	args := []string{head + shellQuote(r.URL.String())}
+	if host := hostOverride(r); host != "" {
+		args = append(args, "-H "+shellQuote("Host: "+host))
+	}
+	for _, h := range commandHeaders(r.Header) {
+		if h[1] == "" {
+			// "Name:" would remove the header; "Name;" sends it empty.
+			args = append(args, "-H "+shellQuote(h[0]+";"))
+			continue
+		}
+		args = append(args, "-H "+shellQuote(h[0]+": "+h[1]))
+	}
+	if r.Header.Get("Accept-Encoding") != "" {
+		args = append(args, "--compressed")
+	}
+	if body := ex.decodedReqBody(); len(body) > 0 {
+		if bodyFile != "" {
+			args = append(args, "--data-binary "+shellQuote("@"+bodyFile))
+		} else {
+			args = append(args, "--data-binary "+shellQuote(string(body)))
+		}
+	}
+	return strings.Join(args, commandContinuation)
+}
+
+// httpieCommand returns the HTTPie equivalent of curlCommand. The body is sent with --raw
+// so HTTPie does not re-encode it, or redirected from bodyFile when it is binary.
+func httpieCommand(ex *exchange, bodyFile string) string {
+	r := ex.Request
+	args := []string{"http " + shellQuote(r.Method) + " " + shellQuote(r.URL.String())}
+	if host := hostOverride(r); host != "" {
+		args = append(args, shellQuote("Host:"+host))
+	}
+	for _, h := range commandHeaders(r.Header) {
+		if h[1] == "" {
+			args = append(args, shellQuote(h[0]+";"))
+			continue
+		}
+		args = append(args, shellQuote(h[0]+":"+h[1]))
+	}
+	if body := ex.decodedReqBody(); len(body) > 0 {
+		if bodyFile != "" {
+			args = append(args, "< "+shellQuote(bodyFile))
+		} else {
+			args = append(args, "--raw "+shellQuote(string(body)))
+		}
+	}
+	return strings.Join(args, commandContinuation)
+}
+
+// hostOverride returns the Host header of r when it differs from the host of its URL.
+func hostOverride(r *http.Request) string {
+	if r.Host != "" && r.Host != r.URL.Host {
+		return r.Host
+	}
+	return ""
+}
+
+// commandHeaders returns the name/value pairs of header worth repeating, sorted by name.
+func commandHeaders(header http.Header) [][2]string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		if !curlSkipHeaders[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var pairs [][2]string
+	for _, name := range names {
+		for _, v := range header[name] {
+			pairs = append(pairs, [2]string{name, v})
+		}
+	}
+	return pairs
+}
+
+// isBinaryBody reports whether body cannot be written on a command line as text: it is
+// not valid UTF-8 or holds control characters other than whitespace.
+func isBinaryBody(body []byte) bool {
+	if !utf8.Valid(body) {
+		return true
+	}
+	for _, r := range string(body) {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return true
+		}
+	}
+	return false
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s for POSIX shells, leaving plain words as they are.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// bodyStore writes binary request bodies to files that generated commands refer to.
+// Without a directory, a temporary one is created on first use.
+type bodyStore struct {
+	dir string
+
+	once sync.Once
+	err  error
+}
+
+// Save returns the file holding the decoded request body of ex, or "" when the body is
+// empty or text and can be passed inline.
+func (s *bodyStore) Save(ex *exchange) (string, error) {
+	body := ex.decodedReqBody()
+	if s == nil || len(body) == 0 || !isBinaryBody(body) {
+		return "", nil
+	}
+	s.once.Do(func() {
+		if s.dir == "" {
+			s.dir, s.err = os.MkdirTemp("", "http-proxy-logger-bodies-")
+			return
+		}
+		if s.err = os.MkdirAll(s.dir, 0o750); s.err == nil {
+			s.dir, s.err = filepath.Abs(s.dir)
+		}
+	})
+	if s.err != nil {
+		return "", s.err
+	}
+	name := filepath.Join(s.dir, fmt.Sprintf("request-%06d.bin", ex.ID))
+	if err := os.WriteFile(name, body, 0o600); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// commandGenerator renders logged requests as curl and HTTPie commands.
+type commandGenerator struct {
+	curl   bool
+	httpie bool
+	bodies *bodyStore
+}
+
+// Commands returns the enabled commands for ex. If a binary body cannot be saved the
+// error is returned along with commands that pass the body inline.
+func (g *commandGenerator) Commands(ex *exchange) (curl, httpie string, err error) {
+	file, err := g.bodies.Save(ex)
+	if g.curl {
+		curl = curlCommand(ex, file)
+	}
+	if g.httpie {
+		httpie = httpieCommand(ex, file)
+	}
+	return curl, httpie, err
+}
+
+// Block formats the enabled commands for the text log, one paragraph each.
+func (g *commandGenerator) Block(ex *exchange) string {
+	curl, httpie, err := g.Commands(ex)
+	var b strings.Builder
+	if err != nil {
+		fmt.Fprintf(&b, "# request body passed inline: %v\n", err)
+	}
+	for _, cmd := range []string{curl, httpie} {
+		if cmd != "" {
+			b.WriteString(cmd + "\n\n")
+		}
+	}
+	return b.String()
+}
+
+// writeCommandScript converts the recording in dir into a shell script that repeats every
+// recorded request in order, as curl commands or, with httpie set, HTTPie commands.
+// Recordings are verbatim, so the script carries the original credentials.
+func writeCommandScript(w io.Writer, dir string, httpie bool, bodies *bodyStore) error {
+	recs, err := loadRecording(dir)
+	if err != nil {
+		return err
+	}
+	g := &commandGenerator{curl: !httpie, httpie: httpie, bodies: bodies}
+	if _, err := fmt.Fprintf(w, "#!/bin/sh\n# Requests recorded in %s (%d)\n", dir, len(recs)); err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		ex, err := rec.exchange()
+		if err != nil {
+			return err
+		}
+		curl, httpie, err := g.Commands(ex)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "\n# exchange %d: %s %s -> %d\n%s%s\n", rec.ID, rec.Method, rec.URL, rec.Status, curl, httpie); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{in: "https://api.test/v1/users", want: "https://api.test/v1/users"},
+		{in: "a b", want: "'a b'"},
+		{in: "it's", want: `'it'\''s'`},
+		{in: "", want: "''"},
+		{in: "$HOME", want: "'$HOME'"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCurlCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header http.Header
+		host   string
+		body   string
+		want   []string
+	}{
+		{name: "get", method: http.MethodGet, url: "http://api.test/users?page=2", want: []string{"curl 'http://api.test/users?page=2'"}},
+		{name: "head", method: http.MethodHead, url: "http://api.test/", want: []string{"curl --head http://api.test/"}},
+		{
+			name: "post", method: http.MethodPost, url: "http://api.test/orders",
+			header: http.Header{"Content-Type": {"application/json"}, "X-Trace": {"a", "b"}, "Content-Length": {"12"}},
+			body:   `{"note":"it's"}`,
+			want: []string{
+				"curl -X POST http://api.test/orders \\\n  -H 'Content-Type",
+				"-H 'Content-Type: application/json' \\\n  -H 'X-Trace: a' \\\n  -H 'X-Trace: b'",
+				`--data-binary '{"note":"it'\''s"}'`,
+			},
+		},
+		{
+			name: "host and compression", method: http.MethodGet, url: "http://10.0.0.5/", host: "api.test",
+			header: http.Header{"Accept-Encoding": {"gzip"}},
+			want:   []string{"-H 'Host: api.test'", "--compressed"},
+		},
+		{
+			name: "empty header", method: http.MethodGet, url: "http://api.test/",
+			header: http.Header{"X-Empty": {""}},
+			want:   []string{"-H 'X-Empty;'"},
+		},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.url, nil)
+		if tt.header != nil {
+			req.Header = tt.header
+		}
+		if tt.host != "" {
+			req.Host = tt.host
+		}
+		got := curlCommand(&exchange{Request: req, ReqBody: []byte(tt.body)}, "")
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: missing %q in\n%s", tt.name, want, got)
+			}
+		}
+		if strings.Contains(got, "Content-Length") || strings.Contains(got, "Accept-Encoding") {
+			t.Errorf("%s: transport headers repeated:\n%s", tt.name, got)
+		}
+	}
+}
+
+func TestHTTPieCommand(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "http://10.0.0.5/orders?dry=1", nil)
+	req.Host = "api.test"
+	req.Header = http.Header{"Content-Type": {"application/json"}, "Accept-Encoding": {"gzip"}, "X-Empty": {""}}
+	got := httpieCommand(&exchange{Request: req, ReqBody: []byte(`{"note":"it's"}`)}, "")
+	want := "http POST 'http://10.0.0.5/orders?dry=1' \\\n  Host:api.test \\\n  Content-Type:application/json \\\n  'X-Empty;' \\\n  --raw '{\"note\":\"it'\\''s\"}'"
+	if got != want {
+		t.Errorf("httpieCommand =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestIsBinaryBody(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{in: "{\"a\": 1}\n", want: false},
+		{in: "tab\tand\r\nnewline", want: false},
+		{in: "grüße", want: false},
+		{in: "\x00\x01", want: true},
+		{in: "\xff\xfe", want: true},
+	}
+	for _, tt := range tests {
+		if got := isBinaryBody([]byte(tt.in)); got != tt.want {
+			t.Errorf("isBinaryBody(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBinaryBodyCommands(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bodies")
+	g := &commandGenerator{curl: true, httpie: true, bodies: &bodyStore{dir: dir}}
+
+	req, _ := http.NewRequest(http.MethodPut, "http://api.test/upload", nil)
+	body := []byte{0x89, 'P', 'N', 'G', 0x00}
+	curl, httpie, err := g.Commands(&exchange{ID: 3, Request: req, ReqBody: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "request-000003.bin")
+	if data, err := os.ReadFile(file); err != nil || !bytes.Equal(data, body) {
+		t.Fatalf("body file = %q (%v)", data, err)
+	}
+	if !strings.Contains(curl, "--data-binary @"+file) {
+		t.Errorf("curl does not read the body file:\n%s", curl)
+	}
+	if !strings.HasSuffix(httpie, "< "+file) {
+		t.Errorf("httpie does not read the body file:\n%s", httpie)
+	}
+
+	curl, _, err = g.Commands(&exchange{ID: 4, Request: req, ReqBody: []byte("text")})
+	if err != nil || !strings.Contains(curl, "--data-binary text") {
+		t.Errorf("text body not inlined: %s (%v)", curl, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "request-000004.bin")); !os.IsNotExist(err) {
+		t.Errorf("text body written to a file: %v", err)
+	}
+}
+
+func TestWriteCommandScript(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := newRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := testExchange(1, http.MethodGet, "http://api.test/users", 200, "[]")
+	get.Request.Header.Set("Authorization", "Bearer secret")
+	post := testExchange(2, http.MethodPost, "http://api.test/blobs", 201, "{}")
+	post.ReqBody = []byte{0x00, 0xff}
+	rec.Add(get)
+	rec.Add(post)
+
+	for _, tt := range []struct {
+		name   string
+		httpie bool
+		want   []string
+	}{
+		{name: "curl", want: []string{
+			"#!/bin/sh\n# Requests recorded in " + dir + " (2)",
+			"# exchange 1: GET http://api.test/users -> 200\ncurl http://api.test/users \\\n  -H 'Authorization: Bearer secret'",
+			"# exchange 2: POST http://api.test/blobs -> 201\ncurl -X POST http://api.test/blobs \\\n  --data-binary @" + filepath.Join(dir, "request-000002.bin"),
+		}},
+		{name: "httpie", httpie: true, want: []string{
+			"http GET http://api.test/users \\\n  'Authorization:Bearer secret'",
+			"http POST http://api.test/blobs \\\n  < " + filepath.Join(dir, "request-000002.bin"),
+		}},
+	} {
+		var out bytes.Buffer
+		if err := writeCommandScript(&out, dir, tt.httpie, &bodyStore{dir: dir}); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: script missing %q:\n%s", tt.name, want, out.String())
+			}
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "request-000002.bin")); err != nil || !bytes.Equal(data, post.ReqBody) {
+		t.Errorf("body file = %q (%v)", data, err)
+	}
+}
+
+func TestRoundTripPrintsCommands(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	redact, err := newRedactor(nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := DebugTransport{Redact: redact, Commands: &commandGenerator{curl: true, httpie: true}}
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL+"/items", strings.NewReader(`{"a":1}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	out := buf.String()
+	curlAt := strings.Index(out, "curl -X POST "+upstream.URL+"/items")
+	if curlAt == -1 || curlAt > strings.Index(out, "--- RESPONSE") {
+		t.Fatalf("no curl command in the request block:\n%s", out)
+	}
+	for _, want := range []string{"--data-binary '{\"a\":1}'", "http POST " + upstream.URL + "/items", "--raw '{\"a\":1}'"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("generated commands leak the redacted header:\n%s", out)
+	}
+}
+
+func TestRoundTripCommandsChunkedBody(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	transport := DebugTransport{Commands: &commandGenerator{curl: true, httpie: true}}
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL+"/items", io.NopCloser(strings.NewReader(`{"a":1}`)))
+	req.ContentLength = -1 // sent chunked
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	out := buf.String()
+	for _, want := range []string{"--data-binary '{\"a\":1}'", "--raw '{\"a\":1}'"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Server timeouts for the proxy listener. They bound slow or stalled clients; exchanges
+// that keep moving data push them forward (see deadlines), so long downloads and streams
+// are not cut off.
+const (
+	serverReadTimeout  = 30 * time.Second
+	serverWriteTimeout = 60 * time.Second
+)
+
+// deadlines controls the read and write deadlines of the client connection serving a
+// request. A nil *deadlines does nothing, e.g. for requests that did not come through
+// withDeadlines.
+type deadlines struct {
+	rc          *http.ResponseController
+	read, write time.Duration
+}
+
+type deadlinesKey struct{}
+
+// withDeadlines makes the connection deadlines of each request available to
+// DebugTransport through the request context. read and write are the server's
+// ReadTimeout and WriteTimeout.
+func withDeadlines(next http.Handler, read, write time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := &deadlines{rc: http.NewResponseController(w), read: read, write: write}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), deadlinesKey{}, d)))
+	})
+}
+
+// deadlinesFrom returns the deadlines stored by withDeadlines, or nil.
+func deadlinesFrom(ctx context.Context) *deadlines {
+	d, _ := ctx.Value(deadlinesKey{}).(*deadlines)
+	return d
+}
+
+// Extend restarts both timeouts from now. The read deadline matters even while only the
+// response is written: when it passes, the server cancels the request context.
+func (d *deadlines) Extend() {
+	if d == nil {
+		return
+	}
+	now := time.Now()
+	if d.read > 0 {
+		_ = d.rc.SetReadDeadline(now.Add(d.read))
+	}
+	if d.write > 0 {
+		_ = d.rc.SetWriteDeadline(now.Add(d.write))
+	}
+}
+
+// Clear removes both deadlines for responses that stay open indefinitely.
+func (d *deadlines) Clear() {
+	if d == nil {
+		return
+	}
+	_ = d.rc.SetReadDeadline(time.Time{})
+	_ = d.rc.SetWriteDeadline(time.Time{})
+}
+
+// Track returns body extending the deadlines whenever data arrives, so a transfer only
+// times out when it stalls for a whole timeout.
+func (d *deadlines) Track(body io.ReadCloser) io.ReadCloser {
+	if d == nil || body == nil || body == http.NoBody {
+		return body
+	}
+	return &progressBody{ReadCloser: body, d: d}
+}
+
+// progressBody extends its deadlines after every read that returned data.
+type progressBody struct {
+	io.ReadCloser
+	d *deadlines
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.d.Extend()
+	}
+	return n, err
+}
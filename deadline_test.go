@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowUpstream writes chunks of a body with a pause before each one.
+func slowUpstream(chunks int, pause time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		for range chunks {
+			time.Sleep(pause)
+			_, _ = io.WriteString(w, "chunk\n")
+			w.(http.Flusher).Flush() //nolint:forcetypeassert // httptest servers support flushing
+		}
+	}))
+}
+
+// newTimeoutProxy serves transport with the given server timeouts, optionally wrapped in
+// withDeadlines as main does.
+func newTimeoutProxy(transport DebugTransport, target *url.URL, timeout time.Duration, track bool) *httptest.Server {
+	var handler http.Handler = &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite:   func(pr *httputil.ProxyRequest) { pr.SetURL(target) },
+	}
+	if track {
+		handler = withDeadlines(handler, timeout, timeout)
+	}
+	proxy := httptest.NewUnstartedServer(handler)
+	proxy.Config.ReadTimeout = timeout
+	proxy.Config.WriteTimeout = timeout
+	proxy.Config.ConnContext = transport.Throttle.ConnContext
+	proxy.Start()
+	return proxy
+}
+
+func TestStreamingOutlastsServerTimeouts(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := slowUpstream(8, 50*time.Millisecond)
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	for _, track := range []bool{true, false} {
+		proxy := newTimeoutProxy(DebugTransport{}, target, 150*time.Millisecond, track)
+		resp, err := http.Get(proxy.URL)
+		var body []byte
+		if err == nil {
+			body, err = io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+		}
+		complete := err == nil && strings.Count(string(body), "chunk") == 8
+		if complete != track {
+			t.Errorf("withDeadlines %v: read %d chunks, err %v", track, strings.Count(string(body), "chunk"), err)
+		}
+		proxy.Close()
+	}
+}
+
+func TestDeadlinesNil(t *testing.T) {
+	var d *deadlines
+	d.Extend()
+	body := io.NopCloser(strings.NewReader("x"))
+	if d.Track(body) != body {
+		t.Error("nil deadlines wrapped the body")
+	}
+	if deadlinesFrom(httptest.NewRequest(http.MethodGet, "/", nil).Context()) != nil {
+		t.Error("deadlines found without withDeadlines")
+	}
+}
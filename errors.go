@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// upstreamError is returned by DebugTransport.RoundTrip when the upstream could not be
+// reached. It carries the exchange so ErrorHandler can log and answer it.
+type upstreamError struct {
+	ex      *exchange
+	visible bool
+	err     error
+}
+
+func (e *upstreamError) Error() string { return e.err.Error() }
+
+func (e *upstreamError) Unwrap() error { return e.err }
+
+// classifyError names the kind of upstream failure and picks the status returned to the client:
+// 504 for timeouts, 502 for everything else.
+func classifyError(err error) (string, int) {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var recordErr tls.RecordHeaderError
+	switch {
+	case errors.Is(err, errFaultReset):
+		return "injected reset", http.StatusBadGateway
+	case errors.Is(err, context.Canceled):
+		return "client canceled", http.StatusBadGateway
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout", http.StatusGatewayTimeout
+	case errors.As(err, &dnsErr):
+		return "dns lookup failed", http.StatusBadGateway
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused", http.StatusBadGateway
+	case errors.As(err, &verifyErr), errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr),
+		errors.As(err, &recordErr), strings.Contains(err.Error(), "tls: "):
+		return "tls error", http.StatusBadGateway
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection reset", http.StatusBadGateway
+	default:
+		return "upstream error", http.StatusBadGateway
+	}
+}
+
+// ErrorHandler is the ReverseProxy error hook. It logs a "--- ERROR n ---" entry for the
+// failed exchange and answers the client with a descriptive 502 or 504 body, as JSON when
+// the client accepts it and as plain text otherwise. Injected resets get no answer at all.
+func (t DebugTransport) ErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	var ue *upstreamError
+	var ex *exchange
+	visible := true
+	if errors.As(err, &ue) {
+		ex, visible = ue.ex, ue.visible
+	} else {
+		// Failures outside RoundTrip, e.g. a failed protocol switch.
+		ex = &exchange{ID: reqCounter.Add(1), Request: r, Start: time.Now(), End: time.Now()}
+	}
+	ex.Err = err
+	class, status := classifyError(err)
+	if ue != nil {
+		t.Metrics.Failed(ex, class, status)
+	}
+	if visible {
+		t.logError(ex, class, status)
+		view := t.Redact.View(ex)
+		t.UI.Add(view)
+		t.TUI.Add(view)
+	}
+	if errors.Is(err, errFaultReset) {
+		// Closes the client connection without writing a response.
+		panic(http.ErrAbortHandler)
+	}
+	writeErrorResponse(w, r, ex, class, status)
+}
+
+// logError prints the failed exchange. In -paired mode the held request block comes first.
+func (t DebugTransport) logError(ex *exchange, class string, status int) {
+	view := t.Redact.View(ex)
+	if *logFormat == formatJSONL {
+		rec := newJSONLRecord(view)
+		rec.Status = status
+		rec.Error = &jsonlError{Class: class, Message: ex.Err.Error()}
+		writeJSONL(rec)
+		return
+	}
+	head := wrapColor(fmt.Sprintf("--- ERROR %d (%s)", ex.ID, class), colorStatus5xx)
+	duration := wrapColor(formatMillis(ex.Duration()), colorDuration(ex.Duration(), *slowThreshold))
+	tail := wrapColor(view.labelSuffix()+" ---", colorStatus5xx)
+	target := view.Request.Method + " " + view.Request.URL.String()
+	block := fmt.Sprintf("%s %s %s%s\n\n%s\n%s\n\n", coloredTime(time.Now(), colorStatus5xx), head, duration, tail,
+		target, wrapColor(ex.Err.Error(), colorStatus5xx))
+	logEntry(ex.ID, ex.pendingRequest+block)
+}
+
+// writeErrorResponse answers the client after an upstream failure.
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, ex *exchange, class string, status int) {
+	if acceptsJSON(r) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"error":      class,
+			"message":    ex.Err.Error(),
+			"status":     status,
+			"target":     ex.Request.URL.String(),
+			"exchange":   ex.ID,
+			"elapsed_ms": millis(ex.Duration()),
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write(append(body, '\n'))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "%d %s: %s (exchange %d, after %s)\ntarget: %s\nerror: %v\n",
+		status, http.StatusText(status), class, ex.ID, formatMillis(ex.Duration()), ex.Request.URL, ex.Err)
+}
+
+// acceptsJSON reports whether the client asked for JSON or sent JSON itself.
+func acceptsJSON(r *http.Request) bool {
+	for _, v := range append(r.Header.Values("Accept"), r.Header.Get("Content-Type")) {
+		for _, part := range strings.Split(v, ",") {
+			mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(part))
+			if strings.HasSuffix(mediaType, "/json") || strings.HasSuffix(mediaType, "+json") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		class  string
+		status int
+	}{
+		{"deadline", context.DeadlineExceeded, "timeout", http.StatusGatewayTimeout},
+		{"canceled", fmt.Errorf("wrapped: %w", context.Canceled), "client canceled", http.StatusBadGateway},
+		{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "nope.invalid"}}, "dns lookup failed", http.StatusBadGateway},
+		{"refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, "connection refused", http.StatusBadGateway},
+		{"unknown authority", &url.Error{Op: "Get", Err: x509.UnknownAuthorityError{}}, "tls error", http.StatusBadGateway},
+		{"reset", io.ErrUnexpectedEOF, "connection reset", http.StatusBadGateway},
+		{"injected reset", errFaultReset, "injected reset", http.StatusBadGateway},
+		{"other", errors.New("boom"), "upstream error", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class, status := classifyError(tt.err)
+			if class != tt.class || status != tt.status {
+				t.Errorf("classifyError() = %q, %d; want %q, %d", class, status, tt.class, tt.status)
+			}
+		})
+	}
+}
+
+func TestAcceptsJSON(t *testing.T) {
+	tests := []struct {
+		accept, contentType string
+		want                bool
+	}{
+		{"application/json", "", true},
+		{"text/html, application/problem+json;q=0.9", "", true},
+		{"*/*", "application/json; charset=utf-8", true},
+		{"text/html", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		if got := acceptsJSON(r); got != tt.want {
+			t.Errorf("acceptsJSON(Accept=%q, Content-Type=%q) = %v, want %v", tt.accept, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestErrorHandlerRefusedAndTimeout(t *testing.T) {
+	originalNoColor, originalPaired := *noColor, *pairedOutput
+	*noColor, *pairedOutput = true, true
+	defer func() { *noColor, *pairedOutput = originalNoColor, originalPaired }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused, _ := url.Parse("http://" + l.Addr().String())
+	_ = l.Close()
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	slowURL, _ := url.Parse(slow.URL)
+
+	newProxy := func(target *url.URL) *httptest.Server {
+		transport := DebugTransport{Upstream: &http.Transport{ResponseHeaderTimeout: 50 * time.Millisecond}}
+		return httptest.NewServer(&httputil.ReverseProxy{
+			Transport:    transport,
+			Rewrite:      func(pr *httputil.ProxyRequest) { pr.SetURL(target) },
+			ErrorHandler: transport.ErrorHandler,
+		})
+	}
+
+	down := newProxy(refused)
+	defer down.Close()
+	resp, err := http.Get(down.URL + "/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || !strings.Contains(string(body), "connection refused") ||
+		!strings.Contains(string(body), refused.Host) {
+		t.Errorf("refused: got %d %q", resp.StatusCode, body)
+	}
+	out := buf.String()
+	if !regexp.MustCompile(`(?s)--- REQUEST (\d+) ---.*GET /users.*--- ERROR (\d+) \(connection refused\)`).MatchString(out) {
+		t.Errorf("expected the request followed by an ERROR entry:\n%s", out)
+	}
+
+	timeout := newProxy(slowURL)
+	defer timeout.Close()
+	req, _ := http.NewRequest(http.MethodGet, timeout.URL+"/slow", nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload struct {
+		Error    string `json:"error"`
+		Status   int    `json:"status"`
+		Exchange int64  `json:"exchange"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout || payload.Error != "timeout" || payload.Status != 504 || payload.Exchange == 0 {
+		t.Errorf("timeout: got %d %+v", resp.StatusCode, payload)
+	}
+	if !strings.Contains(buf.String(), fmt.Sprintf("--- ERROR %d (timeout)", payload.Exchange)) {
+		t.Errorf("timeout not logged:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exchange describes one request/response pair as seen by DebugTransport.
+// It is built incrementally and handed to the output sinks once the response body completes.
+type exchange struct {
+	ID       int64
+	Request  *http.Request
+	ReqBody  []byte // raw request body as sent upstream
+	Response *http.Response
+	RespBody []byte // raw response body, capped at maxLogBodySize
+	RespSize int64  // total response body bytes delivered to the client
+	Start    time.Time
+	Headers  time.Time // response headers received
+	End      time.Time // response body finished or closed
+	Timings  phaseTimings
+
+	EventStream bool  // response is a text/event-stream logged event by event
+	Events      int   // number of Server-Sent Events seen so far
+	WebSocket   bool  // response upgraded the connection to a WebSocket
+	Frames      int64 // number of WebSocket messages and control frames seen
+
+	// Hidden exchanges were excluded by the filters: they are proxied but not logged.
+	Hidden bool
+
+	// Throttled exchanges were paced by -throttle-*/-rtt; their log shows the throughput.
+	Throttled bool
+
+	// Err is set when the upstream could not be reached; Response is then nil.
+	Err error
+
+	// Inbound is the request as received from the client, captured with -inbound.
+	Inbound *inboundRequest
+
+	// Labels mark exchanges that did not simply pass through, e.g. "replayed".
+	Labels []string
+
+	// RequestDiff and ResponseDiff show what -rewrite rules changed, as redacted diff lines.
+	RequestDiff  []string
+	ResponseDiff []string
+
+	// rewrites are the -rewrite rules matching the request as received.
+	rewrites []*rewriteRule
+
+	// pendingRequest holds the formatted request block in -paired mode until it can be
+	// printed together with the response.
+	pendingRequest string
+
+	// decoded is set on redacted views whose bodies no longer carry their Content-Encoding;
+	// such views remember truncation explicitly since the body length changed.
+	decoded   bool
+	truncated bool
+}
+
+// decodedReqBody returns the request body with its Content-Encoding removed.
+// Bodies that fail to decode are returned as-is.
+func (e *exchange) decodedReqBody() []byte {
+	if e.decoded {
+		return e.ReqBody
+	}
+	body, err := decodeBody(e.Request.Header.Get("Content-Encoding"), e.ReqBody)
+	if err != nil {
+		return e.ReqBody
+	}
+	return body
+}
+
+// decodedRespBody returns the captured response body with its Content-Encoding removed.
+// Bodies that fail to decode are returned as-is.
+func (e *exchange) decodedRespBody() []byte {
+	if e.decoded {
+		return e.RespBody
+	}
+	body, err := decodeBody(e.Response.Header.Get("Content-Encoding"), e.RespBody)
+	if err != nil {
+		return e.RespBody
+	}
+	return body
+}
+
+// labelSuffix formats Labels for the end of a log marker line, e.g. " [replayed]".
+func (e *exchange) labelSuffix() string {
+	if len(e.Labels) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(e.Labels, ", ") + "]"
+}
+
+// Truncated reports whether the captured response body is shorter than what was proxied.
+func (e *exchange) Truncated() bool {
+	if e.decoded {
+		return e.truncated
+	}
+	return e.RespSize > int64(len(e.RespBody))
+}
+
+// Duration returns the total time from sending the request to finishing the response body.
+func (e *exchange) Duration() time.Duration {
+	return e.End.Sub(e.Start)
+}
+
+// renderedExchange holds the highlighted sections of an exchange for the interactive UIs.
+type renderedExchange struct {
+	RequestHeaders  string
+	RequestBody     string
+	ResponseHeaders string // empty when the upstream could not be reached
+	ResponseBody    string
+}
+
+// render formats the headers and bodies of e with p. Raw bodies are decoded but shown as
+// received instead of pretty-printed.
+func (e *exchange) render(p painter, raw bool) renderedExchange {
+	var out renderedExchange
+	// The request's own context is canceled once it has been proxied, which would fail the dump.
+	// WithContext also returns a shallow copy, so the body swap inside DumpRequestOut stays local.
+	if dump, err := httputil.DumpRequestOut(e.Request.WithContext(context.Background()), false); err == nil {
+		out.RequestHeaders = string(p.headers(bytes.TrimSuffix(dump, []byte("\r\n\r\n")), true))
+	}
+	out.RequestBody = renderBody(p, e.decodedReqBody(), e.Request.Header.Get("Content-Type"), raw)
+	if e.Response == nil {
+		return out
+	}
+	if dump, err := dumpResponseHead(e.Response); err == nil {
+		out.ResponseHeaders = string(p.headers(dump, false))
+	}
+	switch {
+	case e.WebSocket:
+		out.ResponseBody = p.text(fmt.Sprintf("[websocket: %d frames]", e.Frames))
+	case e.Truncated():
+		out.ResponseBody = p.text(fmt.Sprintf("[body too large to display: %d bytes]", e.RespSize))
+	default:
+		out.ResponseBody = renderBody(p, e.decodedRespBody(), e.Response.Header.Get("Content-Type"), raw)
+	}
+	return out
+}
+
+func renderBody(p painter, body []byte, contentType string, raw bool) string {
+	if raw {
+		return p.text(string(body))
+	}
+	return string(p.body(body, contentType))
+}
+
+// captureBody wraps an upstream response body. Bytes are passed to the reader unchanged
+// as they arrive, while up to limit bytes are kept for logging. onDone runs exactly once,
+// when the body reaches EOF or is closed, whichever happens first.
+type captureBody struct {
+	rc     io.ReadCloser
+	limit  int
+	buf    bytes.Buffer
+	size   int64
+	once   sync.Once
+	mu     sync.Mutex
+	onDone func(data []byte, size int64)
+}
+
+// newCaptureBody returns a captureBody reading from rc.
+func newCaptureBody(rc io.ReadCloser, limit int, onDone func(data []byte, size int64)) *captureBody {
+	return &captureBody{rc: rc, limit: limit, onDone: onDone}
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if n > 0 {
+		b.mu.Lock()
+		b.size += int64(n)
+		if room := b.limit - b.buf.Len(); room > 0 {
+			b.buf.Write(p[:min(n, room)])
+		}
+		b.mu.Unlock()
+	}
+	if errors.Is(err, io.EOF) {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *captureBody) Close() error {
+	err := b.rc.Close()
+	b.finish()
+	return err
+}
+
+func (b *captureBody) finish() {
+	b.once.Do(func() {
+		b.mu.Lock()
+		data, size := b.buf.Bytes(), b.size
+		b.mu.Unlock()
+		b.onDone(data, size)
+	})
+}
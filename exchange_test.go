@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		limit     int
+		readAll   bool
+		wantData  string
+		wantSize  int64
+		wantCalls int
+	}{
+		{name: "read to EOF", body: "hello world", limit: 64, readAll: true, wantData: "hello world", wantSize: 11, wantCalls: 1},
+		{name: "capture is capped", body: "hello world", limit: 5, readAll: true, wantData: "hello", wantSize: 11, wantCalls: 1},
+		{name: "closed before EOF", body: "hello world", limit: 64, readAll: false, wantData: "", wantSize: 0, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			var gotData []byte
+			var gotSize int64
+			b := newCaptureBody(io.NopCloser(strings.NewReader(tt.body)), tt.limit, func(data []byte, size int64) {
+				calls++
+				gotData, gotSize = data, size
+			})
+			if tt.readAll {
+				out, err := io.ReadAll(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(out) != tt.body {
+					t.Errorf("passthrough = %q, want %q", out, tt.body)
+				}
+			}
+			_ = b.Close()
+			if calls != tt.wantCalls {
+				t.Errorf("onDone called %d times, want %d", calls, tt.wantCalls)
+			}
+			if string(gotData) != tt.wantData || gotSize != tt.wantSize {
+				t.Errorf("captured %q (%d bytes), want %q (%d bytes)", gotData, gotSize, tt.wantData, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestRoundTripStreamsResponse(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "first chunk\n")
+		_ = http.NewResponseController(w).Flush()
+		<-release
+		_, _ = io.WriteString(w, "second chunk\n")
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	req, err := http.NewRequest(http.MethodGet, upstream.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan *http.Response, 1)
+	go func() {
+		resp, err := DebugTransport{}.RoundTrip(req)
+		if err != nil {
+			t.Errorf("RoundTrip failed: %v", err)
+		}
+		done <- resp
+	}()
+
+	var resp *http.Response
+	select {
+	case resp = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RoundTrip blocked until the upstream body completed")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	buf := make([]byte, len("first chunk\n"))
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte("first chunk\n")) {
+		t.Errorf("got %q before upstream finished", buf)
+	}
+}
+
+func TestExchangeLabelSuffix(t *testing.T) {
+	ex := &exchange{}
+	if got := ex.labelSuffix(); got != "" {
+		t.Errorf("no labels = %q, want empty", got)
+	}
+	ex.Labels = []string{labelReplayed, "no match"}
+	if got := ex.labelSuffix(); got != " [replayed, no match]" {
+		t.Errorf("labelSuffix = %q", got)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// labelFault prefixes the labels of exchanges altered by -fault, e.g. "fault delay 850ms".
+const labelFault = "fault"
+
+// errFaultReset is returned for requests whose connection a -fault rule drops.
+var errFaultReset = errors.New("connection reset by fault injection")
+
+// errFaultTruncated ends a response body cut short by a -fault rule.
+var errFaultTruncated = errors.New("response body truncated by fault injection")
+
+// faultRule alters matching requests. A spec has the form
+//
+//	<condition>[&<condition>...];<action>[;<action>...]
+//
+// Conditions are those of -route; "*" matches every request. Actions:
+//
+//	delay=<duration>[-<duration>]  wait before forwarding, a random time within a range
+//	status=<code>[;body=<text>]    answer without calling the upstream; body comes last
+//	reset                          drop the client connection without a response
+//	truncate=<bytes>               cut the upstream response body after this many bytes
+//	rate=<percent>                 only apply to this share of matching requests
+//
+// Only one of status, reset and truncate may be used per rule.
+type faultRule struct {
+	requestMatch
+	spec     string
+	delayMin time.Duration
+	delayMax time.Duration
+	status   int
+	body     string
+	reset    bool
+	truncate int64 // -1 when the body is passed through whole
+	rate     float64
+}
+
+// parseFaultRule parses a -fault spec such as "/api&method=POST;delay=1s;status=503".
+func parseFaultRule(spec string) (*faultRule, error) {
+	match, actions, ok := strings.Cut(spec, ";")
+	if !ok || match == "" {
+		return nil, fmt.Errorf("invalid fault %q: expected <condition>;<action>", spec)
+	}
+	rule := &faultRule{spec: spec, truncate: -1, rate: 1}
+	if match != "*" {
+		var err error
+		if rule.requestMatch, err = parseRequestMatch(match); err != nil {
+			return nil, fmt.Errorf("invalid fault %q: %w", spec, err)
+		}
+	}
+	outcomes := 0
+	for rest := actions; rest != ""; {
+		var action string
+		if strings.HasPrefix(rest, "body=") {
+			action, rest = rest, ""
+		} else {
+			action, rest, _ = strings.Cut(rest, ";")
+		}
+		name, value, _ := strings.Cut(action, "=")
+		var err error
+		switch name {
+		case "delay":
+			err = rule.parseDelay(value)
+		case "status":
+			outcomes++
+			if rule.status, err = strconv.Atoi(value); err == nil && (rule.status < 100 || rule.status > 999) {
+				err = fmt.Errorf("status %d out of range", rule.status)
+			}
+		case "body":
+			rule.body = value
+		case "reset":
+			outcomes++
+			rule.reset = true
+		case "truncate":
+			outcomes++
+			if rule.truncate, err = strconv.ParseInt(value, 10, 64); err == nil && rule.truncate < 0 {
+				err = fmt.Errorf("negative truncate length")
+			}
+		case "rate":
+			var percent float64
+			percent, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			if err == nil && (percent <= 0 || percent > 100) {
+				err = fmt.Errorf("rate %s must be within (0, 100]", value)
+			}
+			rule.rate = percent / 100
+		default:
+			err = fmt.Errorf("unknown action %q", action)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault %q: %w", spec, err)
+		}
+	}
+	switch {
+	case outcomes > 1:
+		return nil, fmt.Errorf("invalid fault %q: status, reset and truncate are exclusive", spec)
+	case rule.body != "" && rule.status == 0:
+		return nil, fmt.Errorf("invalid fault %q: body requires status", spec)
+	case outcomes == 0 && rule.delayMax == 0:
+		return nil, fmt.Errorf("invalid fault %q: no action", spec)
+	}
+	return rule, nil
+}
+
+// parseDelay parses "500ms" or a range such as "100ms-2s".
+func (rule *faultRule) parseDelay(value string) error {
+	lo, hi, isRange := strings.Cut(value, "-")
+	var err error
+	if rule.delayMin, err = time.ParseDuration(lo); err != nil {
+		return err
+	}
+	rule.delayMax = rule.delayMin
+	if isRange {
+		if rule.delayMax, err = time.ParseDuration(hi); err != nil {
+			return err
+		}
+	}
+	if rule.delayMin < 0 || rule.delayMax < rule.delayMin {
+		return fmt.Errorf("invalid delay %q", value)
+	}
+	return nil
+}
+
+// response builds the synthetic answer of a status rule.
+func (rule *faultRule) response(r *http.Request) *http.Response {
+	body := rule.body
+	if body == "" {
+		body = fmt.Sprintf("injected fault: %d %s\n", rule.status, http.StatusText(rule.status))
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return syntheticResponse(r, rule.status, header, []byte(body))
+}
+
+// faultInjector picks the fault, if any, for each request: the first rule that matches
+// and wins its rate roll. A nil *faultInjector injects nothing.
+type faultInjector struct {
+	rules  []*faultRule
+	random func() float64 // uniform in [0, 1)
+}
+
+// newFaultInjector parses the -fault specs.
+func newFaultInjector(specs []string) (*faultInjector, error) {
+	//nolint:gosec // fault selection and jitter do not need a secure source
+	fi := &faultInjector{random: rand.Float64}
+	for _, spec := range specs {
+		rule, err := parseFaultRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		fi.rules = append(fi.rules, rule)
+	}
+	return fi, nil
+}
+
+// fault is the outcome chosen for one request.
+type fault struct {
+	rule  *faultRule
+	delay time.Duration
+}
+
+// Pick returns the fault to inject into r, or nil.
+func (fi *faultInjector) Pick(r *http.Request) *fault {
+	if fi == nil {
+		return nil
+	}
+	for _, rule := range fi.rules {
+		if !rule.Match(r) || (rule.rate < 1 && fi.random() >= rule.rate) {
+			continue
+		}
+		f := &fault{rule: rule, delay: rule.delayMin}
+		if rule.delayMax > rule.delayMin {
+			f.delay += time.Duration(fi.random() * float64(rule.delayMax-rule.delayMin))
+		}
+		return f
+	}
+	return nil
+}
+
+// Labels names what the fault does for the log marker lines. A nil *fault has none.
+func (f *fault) Labels() []string {
+	if f == nil {
+		return nil
+	}
+	var labels []string
+	if f.delay > 0 {
+		labels = append(labels, fmt.Sprintf("%s delay %s", labelFault, f.delay.Round(time.Millisecond)))
+	}
+	switch rule := f.rule; {
+	case rule.status != 0:
+		labels = append(labels, fmt.Sprintf("%s status %d", labelFault, rule.status))
+	case rule.reset:
+		labels = append(labels, labelFault+" reset")
+	case rule.truncate >= 0:
+		labels = append(labels, fmt.Sprintf("%s truncate %d B", labelFault, rule.truncate))
+	}
+	return labels
+}
+
+// hasFault reports whether labels mark an exchange altered by fault injection.
+func hasFault(labels []string) bool {
+	for _, l := range labels {
+		if strings.HasPrefix(l, labelFault+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// withFault performs the upstream round trip of ex with f applied: it waits out the delay,
+// then answers with the synthetic status, fails with a reset or cuts the body short.
+func (t DebugTransport) withFault(ex *exchange, f *fault) (*http.Response, error) {
+	if f == nil {
+		return t.upstream(ex)
+	}
+	if f.delay > 0 {
+		timer := time.NewTimer(f.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ex.Request.Context().Done():
+			return nil, ex.Request.Context().Err()
+		}
+	}
+	rule := f.rule
+	switch {
+	case rule.reset:
+		return nil, errFaultReset
+	case rule.status != 0:
+		return rule.response(ex.Request), nil
+	}
+	resp, err := t.upstream(ex)
+	if err == nil && rule.truncate >= 0 && resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body = &truncatedBody{ReadCloser: resp.Body, remaining: rule.truncate}
+		// An unknown length makes ReverseProxy flush every write, so the client receives
+		// the headers and the partial body before the connection is aborted. The
+		// Content-Length header is still forwarded as the upstream sent it.
+		resp.ContentLength = -1
+	}
+	return resp, err
+}
+
+// truncatedBody fails with errFaultTruncated once remaining bytes have been read and the
+// body has more, so the proxy aborts the client connection mid-body. A body no longer
+// than remaining ends normally.
+type truncatedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		var next [1]byte
+		if _, err := io.ReadFull(b.ReadCloser, next[:]); err == io.EOF {
+			return 0, io.EOF
+		}
+		return 0, errFaultTruncated
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseFaultRule(t *testing.T) {
+	tests := []struct {
+		spec     string
+		delayMin time.Duration
+		delayMax time.Duration
+		status   int
+		body     string
+		reset    bool
+		truncate int64
+		rate     float64
+	}{
+		{spec: "*;delay=200ms", delayMin: 200 * time.Millisecond, delayMax: 200 * time.Millisecond, truncate: -1, rate: 1},
+		{spec: "/api&method=POST;delay=100ms-2s;rate=25%", delayMin: 100 * time.Millisecond, delayMax: 2 * time.Second, truncate: -1, rate: 0.25},
+		{spec: "header.X-Chaos=1;status=503;body=busy; retry later", status: 503, body: "busy; retry later", truncate: -1, rate: 1},
+		{spec: "/upload;reset;rate=10", reset: true, truncate: -1, rate: 0.1},
+		{spec: "/files/*;truncate=0", truncate: 0, rate: 1},
+	}
+	for _, tt := range tests {
+		rule, err := parseFaultRule(tt.spec)
+		if err != nil {
+			t.Errorf("parseFaultRule(%q): %v", tt.spec, err)
+			continue
+		}
+		if rule.delayMin != tt.delayMin || rule.delayMax != tt.delayMax || rule.status != tt.status || rule.body != tt.body ||
+			rule.reset != tt.reset || rule.truncate != tt.truncate || rule.rate != tt.rate {
+			t.Errorf("parseFaultRule(%q) = %+v", tt.spec, rule)
+		}
+	}
+
+	for _, spec := range []string{
+		"/api",
+		";delay=1s",
+		"/api;rate=50",
+		"/api;delay=2s-1s",
+		"/api;status=42",
+		"/api;body=x",
+		"/api;status=503;reset",
+		"/api;rate=0",
+		"/api;explode",
+		"status=500;delay=1s",
+	} {
+		if _, err := parseFaultRule(spec); err == nil {
+			t.Errorf("parseFaultRule(%q) expected error", spec)
+		}
+	}
+}
+
+func TestFaultInjectorPick(t *testing.T) {
+	fi, err := newFaultInjector([]string{"/slow;delay=1s-3s", "method=POST;status=500;rate=30%", "*;reset;rate=50%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roll := 0.5
+	fi.random = func() float64 { return roll }
+
+	req := func(method, path string) *http.Request {
+		r, _ := http.NewRequest(method, "http://api.test"+path, nil)
+		return r
+	}
+	if f := fi.Pick(req(http.MethodGet, "/slow/x")); f == nil || f.delay != 2*time.Second {
+		t.Errorf("delay fault = %+v", f)
+	} else if got := strings.Join(f.Labels(), ", "); got != "fault delay 2s" {
+		t.Errorf("labels = %q", got)
+	}
+	// The POST rule loses its 30% roll, so the catch-all reset rule (50%) is tried next.
+	roll = 0.4
+	if f := fi.Pick(req(http.MethodPost, "/orders")); f == nil || !f.rule.reset {
+		t.Errorf("expected the reset rule, got %+v", f)
+	}
+	roll = 0.1
+	if f := fi.Pick(req(http.MethodPost, "/orders")); f == nil || strings.Join(f.Labels(), ", ") != "fault status 500" {
+		t.Errorf("expected the status rule, got %+v", f)
+	}
+	roll = 0.9
+	if f := fi.Pick(req(http.MethodGet, "/users")); f != nil {
+		t.Errorf("expected no fault, got %+v", f)
+	}
+	var none *faultInjector
+	if f := none.Pick(req(http.MethodGet, "/")); f != nil || f.Labels() != nil {
+		t.Errorf("nil injector picked %+v", f)
+	}
+}
+
+func TestFaultInjectionThroughProxy(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var upstreamHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		upstreamHits.Add(1)
+		_, _ = io.WriteString(w, strings.Repeat("x", 100))
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	faults, err := newFaultInjector([]string{
+		"/status;status=503;body=try later",
+		"/reset;reset",
+		"/truncate;truncate=10",
+		"/delay;delay=30ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordings := t.TempDir()
+	rec, err := newRecorder(recordings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := DebugTransport{Faults: faults, Recorder: rec}
+	proxy := httptest.NewServer(&httputil.ReverseProxy{
+		Transport:    transport,
+		Rewrite:      func(pr *httputil.ProxyRequest) { pr.SetURL(target) },
+		ErrorHandler: transport.ErrorHandler,
+	})
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || string(body) != "try later" || upstreamHits.Load() != 0 {
+		t.Errorf("status fault: %d %q, upstream hits %d", resp.StatusCode, body, upstreamHits.Load())
+	}
+
+	if resp, err := http.Get(proxy.URL + "/reset"); err == nil {
+		_ = resp.Body.Close()
+		t.Errorf("reset fault answered with %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(proxy.URL + "/truncate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err == nil || len(body) != 10 {
+		t.Errorf("truncate fault: read %d bytes, err %v", len(body), err)
+	}
+
+	start := time.Now()
+	resp, err = http.Get(proxy.URL + "/delay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("delay fault took %s", elapsed)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(buf.String(), "[fault delay 30ms] ---") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"(503 Service Unavailable)",
+		"[fault status 503] ---",
+		"(injected reset)",
+		"[fault reset] ---",
+		"[fault truncate 10 B] ---",
+		"[fault delay 30ms] ---",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+	if files, _ := os.ReadDir(recordings); len(files) != 0 {
+		t.Errorf("faulted exchanges were recorded: %d files", len(files))
+	}
+}
+
+func TestTruncatedBody(t *testing.T) {
+	tests := []struct {
+		body     string
+		truncate int64
+		wantErr  error
+	}{
+		{body: "0123456789", truncate: 4, wantErr: errFaultTruncated},
+		{body: "0123456789", truncate: 10, wantErr: nil},
+		{body: "0123456789", truncate: 20, wantErr: nil},
+		{body: "", truncate: 0, wantErr: nil},
+	}
+	for _, tt := range tests {
+		body := &truncatedBody{ReadCloser: io.NopCloser(strings.NewReader(tt.body)), remaining: tt.truncate}
+		got, err := io.ReadAll(body)
+		want := tt.body[:min(int64(len(tt.body)), tt.truncate)]
+		if err != tt.wantErr || string(got) != want {
+			t.Errorf("truncate=%d of %d bytes: read %q, err %v", tt.truncate, len(tt.body), got, err)
+		}
+	}
+}
+
+func TestFaultMatchesClientHost(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	rtr, err := newRouter(nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faults, err := newFaultInjector([]string{"host=api.local;status=503"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&httputil.ReverseProxy{Transport: DebugTransport{Faults: faults}, Rewrite: rtr.Rewrite})
+	defer proxy.Close()
+
+	for host, want := range map[string]int{"api.local:8888": http.StatusServiceUnavailable, "other.local": http.StatusOK} {
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/", nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Host %s: status %d, want %d", host, resp.StatusCode, want)
+		}
+	}
+}
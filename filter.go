@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// filterCond is one "-filter" expression: <field><op><value>.
+// Fields: method, path, host, status, header.<Name>.
+// Operators: "=" (exact, glob for path/host, comma-separated alternatives),
+// "!=" (negated "="), "~" (regular expression) and "!~" (negated regex).
+type filterCond struct {
+	field  string
+	header string // header name for field "header"
+	negate bool
+	values []string       // for "=" / "!="
+	re     *regexp.Regexp // for "~" / "!~"
+}
+
+// statusRange is an inclusive range of HTTP status codes.
+type statusRange struct{ lo, hi int }
+
+// exchangeFilter decides which exchanges are logged. Unmatched exchanges are still proxied.
+// A nil *exchangeFilter matches everything.
+type exchangeFilter struct {
+	conds        []filterCond
+	excludePaths []string
+	statuses     []statusRange
+}
+
+// newExchangeFilter parses -filter expressions, -exclude-path patterns and -status lists.
+func newExchangeFilter(exprs, excludePaths, statuses []string) (*exchangeFilter, error) {
+	f := &exchangeFilter{excludePaths: excludePaths}
+	for _, e := range exprs {
+		c, err := parseFilterCond(e)
+		if err != nil {
+			return nil, err
+		}
+		f.conds = append(f.conds, c)
+	}
+	for _, s := range statuses {
+		r, err := parseStatusRange(s)
+		if err != nil {
+			return nil, err
+		}
+		f.statuses = append(f.statuses, r)
+	}
+	return f, nil
+}
+
+// parseFilterCond parses expressions such as "method=POST,PUT", "path~^/api/",
+// "header.X-Debug=1" or "status!=404".
+func parseFilterCond(expr string) (filterCond, error) {
+	idx := strings.IndexAny(expr, "=~")
+	if idx <= 0 {
+		return filterCond{}, fmt.Errorf("invalid filter %q: expected <field>=<value> or <field>~<regex>", expr)
+	}
+	c := filterCond{field: strings.ToLower(expr[:idx])}
+	op, value := expr[idx], expr[idx+1:]
+	if strings.HasSuffix(c.field, "!") {
+		c.negate = true
+		c.field = strings.TrimSuffix(c.field, "!")
+	}
+	if name, ok := strings.CutPrefix(c.field, "header."); ok {
+		c.field, c.header = "header", http.CanonicalHeaderKey(name)
+	}
+	switch c.field {
+	case "method", "path", "host", "status", "header":
+	default:
+		return filterCond{}, fmt.Errorf("invalid filter %q: unknown field %q", expr, c.field)
+	}
+	if c.field == "header" && c.header == "" {
+		return filterCond{}, fmt.Errorf("invalid filter %q: missing header name", expr)
+	}
+	if op == '~' {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return filterCond{}, fmt.Errorf("invalid filter %q: %w", expr, err)
+		}
+		c.re = re
+		return c, nil
+	}
+	for _, v := range strings.Split(value, ",") {
+		c.values = append(c.values, strings.TrimSpace(v))
+	}
+	return c, nil
+}
+
+// parseStatusRange accepts "404", "4xx" or "500-599".
+func parseStatusRange(s string) (statusRange, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) == 3 && strings.HasSuffix(s, "xx") {
+		d, err := strconv.Atoi(s[:1])
+		if err == nil && d >= 1 && d <= 5 {
+			return statusRange{d * 100, d*100 + 99}, nil
+		}
+	}
+	if lo, hi, ok := strings.Cut(s, "-"); ok {
+		l, err1 := strconv.Atoi(lo)
+		h, err2 := strconv.Atoi(hi)
+		if err1 == nil && err2 == nil && l <= h {
+			return statusRange{l, h}, nil
+		}
+	}
+	if code, err := strconv.Atoi(s); err == nil {
+		return statusRange{code, code}, nil
+	}
+	return statusRange{}, fmt.Errorf("invalid status %q: expected e.g. 404, 4xx or 500-599", s)
+}
+
+// NeedsResponse reports whether the decision depends on the response, in which case
+// request output must be held back until the response headers arrive.
+func (f *exchangeFilter) NeedsResponse() bool {
+	if f == nil {
+		return false
+	}
+	if len(f.statuses) > 0 {
+		return true
+	}
+	for _, c := range f.conds {
+		if c.field == "status" {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchRequest evaluates every request-side rule.
+func (f *exchangeFilter) MatchRequest(r *http.Request) bool {
+	if f == nil {
+		return true
+	}
+	for _, p := range f.excludePaths {
+		if matchPathPattern(p, r.URL.Path) {
+			return false
+		}
+	}
+	for _, c := range f.conds {
+		if c.field != "status" && !c.match(requestField(r, c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchResponse evaluates the status rules against resp.
+func (f *exchangeFilter) MatchResponse(resp *http.Response) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.statuses) > 0 {
+		ok := false
+		for _, s := range f.statuses {
+			if resp.StatusCode >= s.lo && resp.StatusCode <= s.hi {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, c := range f.conds {
+		if c.field == "status" && !c.match(strconv.Itoa(resp.StatusCode)) {
+			return false
+		}
+	}
+	return true
+}
+
+func requestField(r *http.Request, c filterCond) string {
+	switch c.field {
+	case "method":
+		return r.Method
+	case "path":
+		return r.URL.Path
+	case "host":
+		return clientHost(r)
+	default:
+		return strings.Join(r.Header.Values(c.header), ", ")
+	}
+}
+
+type clientHostKey struct{}
+
+// keepClientHost records the Host the client asked for on the outbound request, whose
+// own Host names the upstream.
+func keepClientHost(pr *httputil.ProxyRequest) {
+	pr.Out = pr.Out.WithContext(context.WithValue(pr.Out.Context(), clientHostKey{}, pr.In.Host))
+}
+
+// clientHost returns the Host the client sent for an outbound request. Requests that did
+// not go through keepClientHost report their own Host.
+func clientHost(r *http.Request) string {
+	if host, ok := r.Context().Value(clientHostKey{}).(string); ok && host != "" {
+		return host
+	}
+	if r.Host != "" {
+		return r.Host
+	}
+	return r.URL.Host
+}
+
+func (c filterCond) match(v string) bool {
+	var ok bool
+	if c.re != nil {
+		ok = c.re.MatchString(v)
+	} else {
+		for _, want := range c.values {
+			if c.equal(want, v) {
+				ok = true
+				break
+			}
+		}
+	}
+	return ok != c.negate
+}
+
+func (c filterCond) equal(want, v string) bool {
+	switch c.field {
+	case "method":
+		return strings.EqualFold(want, v)
+	case "path", "host":
+		if ok, err := path.Match(want, v); err == nil && ok {
+			return true
+		}
+		if c.field == "host" && !strings.Contains(want, ":") {
+			// A pattern without a port matches the host on any port.
+			if host, _, err := net.SplitHostPort(v); err == nil {
+				return c.equal(want, host)
+			}
+		}
+		return want == v
+	case "status":
+		r, err := parseStatusRange(want)
+		code, convErr := strconv.Atoi(v)
+		return err == nil && convErr == nil && code >= r.lo && code <= r.hi
+	default:
+		return want == v
+	}
+}
+
+// matchPathPattern matches glob patterns with path.Match; plain paths match as prefixes
+// on segment boundaries, so "/healthz" excludes "/healthz" and "/healthz/live" but not "/healthzz".
+func matchPathPattern(pattern, p string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, err := path.Match(pattern, p)
+		return err == nil && ok
+	}
+	pattern = strings.TrimSuffix(pattern, "/")
+	return p == pattern || strings.HasPrefix(p, pattern+"/")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatusRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    statusRange
+		wantErr bool
+	}{
+		{in: "404", want: statusRange{404, 404}},
+		{in: "4xx", want: statusRange{400, 499}},
+		{in: " 5XX ", want: statusRange{500, 599}},
+		{in: "500-503", want: statusRange{500, 503}},
+		{in: "9xx", wantErr: true},
+		{in: "503-500", wantErr: true},
+		{in: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseStatusRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseStatusRange(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseStatusRange(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterCondErrors(t *testing.T) {
+	for _, expr := range []string{"method", "=POST", "color=red", "header.=x", "path~[", "header~x"} {
+		if _, err := parseFilterCond(expr); err == nil {
+			t.Errorf("parseFilterCond(%q) expected error", expr)
+		}
+	}
+}
+
+func TestExchangeFilterMatchRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		exprs   []string
+		exclude []string
+		method  string
+		target  string
+		header  http.Header
+		want    bool
+	}{
+		{name: "no rules", method: http.MethodGet, target: "http://h/a", want: true},
+		{name: "method list", exprs: []string{"method=post,put"}, method: http.MethodPut, target: "http://h/a", want: true},
+		{name: "method mismatch", exprs: []string{"method=POST"}, method: http.MethodGet, target: "http://h/a", want: false},
+		{name: "path glob", exprs: []string{"path=/api/*"}, method: http.MethodGet, target: "http://h/api/users", want: true},
+		{name: "path regex", exprs: []string{"path~^/v[0-9]+/"}, method: http.MethodGet, target: "http://h/v2/x", want: true},
+		{name: "negated path", exprs: []string{"path!=/static/*"}, method: http.MethodGet, target: "http://h/static/app.js", want: false},
+		{name: "host", exprs: []string{"host=*.example.com"}, method: http.MethodGet, target: "http://api.example.com/", want: true},
+		{name: "host ignores port", exprs: []string{"host=api.local"}, method: http.MethodGet, target: "http://api.local:1338/", want: true},
+		{name: "host with port", exprs: []string{"host=api.local:80"}, method: http.MethodGet, target: "http://api.local:1338/", want: false},
+		{name: "header regex", exprs: []string{"header.X-Debug~^(1|true)$"}, method: http.MethodGet, target: "http://h/", header: http.Header{"X-Debug": {"true"}}, want: true},
+		{name: "header missing", exprs: []string{"header.X-Debug~."}, method: http.MethodGet, target: "http://h/", want: false},
+		{name: "conditions are ANDed", exprs: []string{"method=POST", "path=/a"}, method: http.MethodPost, target: "http://h/b", want: false},
+		{name: "exclude prefix", exclude: []string{"/healthz"}, method: http.MethodGet, target: "http://h/healthz/live", want: false},
+		{name: "exclude is segment aware", exclude: []string{"/healthz"}, method: http.MethodGet, target: "http://h/healthzz", want: true},
+		{name: "exclude glob", exclude: []string{"/assets/*.css"}, method: http.MethodGet, target: "http://h/assets/site.css", want: false},
+		{name: "status ignored on request side", exprs: []string{"status=5xx"}, method: http.MethodGet, target: "http://h/", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newExchangeFilter(tt.exprs, tt.exclude, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			if got := f.MatchRequest(req); got != tt.want {
+				t.Errorf("MatchRequest = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExchangeFilterMatchResponse(t *testing.T) {
+	f, err := newExchangeFilter([]string{"status!=404"}, nil, []string{"4xx", "5xx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.NeedsResponse() {
+		t.Error("status rules should require the response")
+	}
+	for code, want := range map[int]bool{200: false, 404: false, 401: true, 503: true} {
+		if got := f.MatchResponse(&http.Response{StatusCode: code}); got != want {
+			t.Errorf("MatchResponse(%d) = %v, want %v", code, got, want)
+		}
+	}
+
+	var nilFilter *exchangeFilter
+	if nilFilter.NeedsResponse() || !nilFilter.MatchResponse(&http.Response{StatusCode: 200}) {
+		t.Error("nil filter should match everything without waiting for the response")
+	}
+}
+
+func TestRoundTripFiltersLogging(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = io.WriteString(w, "body of "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	f, err := newExchangeFilter(nil, []string{"/healthz"}, []string{"5xx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := DebugTransport{Filter: f}
+	for _, p := range []string{"/ok", "/healthz", "/fail"} {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+p, nil)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "body of "+p {
+			t.Errorf("filtered exchange not proxied: %q", body)
+		}
+	}
+
+	out := buf.String()
+	if strings.Contains(out, "GET /ok") || strings.Contains(out, "GET /healthz") {
+		t.Errorf("unmatched exchanges were logged:\n%s", out)
+	}
+	if !strings.Contains(out, "GET /fail") || !strings.Contains(out, "500 Internal Server Error") {
+		t.Errorf("matching exchange not logged as a pair:\n%s", out)
+	}
+}
+
+func TestFilterHostThroughProxy(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	rtr, err := newRouter(nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newExchangeFilter([]string{"host=api.local"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&httputil.ReverseProxy{Transport: DebugTransport{Filter: f}, Rewrite: rtr.Rewrite})
+	defer proxy.Close()
+
+	for _, host := range []string{"other.local", "api.local:8888"} {
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/"+strings.Split(host, ".")[0], nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(buf.String(), "--- RESPONSE") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "GET /api HTTP/1.1") || strings.Contains(out, "GET /other") {
+		t.Errorf("host filter did not match the client's Host:\n%s", out)
+	}
+}
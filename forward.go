@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tunnelDialTimeout bounds how long a CONNECT waits for the origin to accept.
+const tunnelDialTimeout = 10 * time.Second
+
+// forwardProxy lets clients use the logger as an HTTP proxy (HTTP_PROXY / HTTPS_PROXY).
+// Absolute-form requests ("GET http://host/path") are sent to their own origin through
+// DebugTransport; CONNECT requests become opaque tunnels that are logged by size and
+// duration. Anything else, i.e. requests addressed to the logger itself, goes to next.
+// With a CA configured, CONNECT tunnels are intercepted instead: the client's TLS is
+// terminated with a minted certificate and the decrypted requests go through DebugTransport.
+type forwardProxy struct {
+	transport DebugTransport
+	proxy     *httputil.ReverseProxy
+	next      http.Handler
+	ca        *certAuthority
+}
+
+// newForwardProxy wraps next with forward-proxy handling. ca enables TLS interception.
+func newForwardProxy(transport DebugTransport, ca *certAuthority, next http.Handler) *forwardProxy {
+	return &forwardProxy{
+		transport: transport,
+		next:      next,
+		ca:        ca,
+		proxy: &httputil.ReverseProxy{
+			Transport: transport,
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(&url.URL{Scheme: pr.In.URL.Scheme, Host: pr.In.URL.Host})
+				keepClientHost(pr)
+			},
+			ErrorHandler: transport.ErrorHandler,
+		},
+	}
+}
+
+func (fp *forwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodConnect:
+		fp.tunnel(w, r)
+	case r.URL.IsAbs():
+		fp.proxy.ServeHTTP(w, r)
+	default:
+		fp.next.ServeHTTP(w, r)
+	}
+}
+
+// tunnel answers a CONNECT request and relays bytes between the client and the origin
+// until either side closes. The payload is not inspected.
+func (fp *forwardProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	id := reqCounter.Add(1)
+	start := time.Now()
+	visible := fp.transport.Filter.MatchRequest(r)
+	if fp.ca != nil {
+		fp.intercept(w, r, id, start, visible)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", r.Host, tunnelDialTimeout)
+	if err != nil {
+		if visible {
+			logTunnelFailed(id, r.Host, err)
+		}
+		http.Error(w, fmt.Sprintf("tunnel to %s failed: %v", r.Host, err), http.StatusBadGateway)
+		return
+	}
+	client, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		_ = upstream.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The server's read/write timeouts would otherwise cut the tunnel off.
+	_ = client.SetDeadline(time.Time{})
+	if _, err := io.WriteString(client, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		_ = client.Close()
+		_ = upstream.Close()
+		return
+	}
+	if visible {
+		logTunnelOpen(id, r.Host, false)
+	}
+
+	var sent, received int64
+	var wg sync.WaitGroup
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			_ = client.Close()
+			_ = upstream.Close()
+		})
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// buffered holds anything the client sent right after the CONNECT header.
+		sent, _ = io.Copy(upstream, buffered.Reader)
+		closeBoth()
+	}()
+	received, _ = io.Copy(client, upstream)
+	closeBoth()
+	wg.Wait()
+
+	if visible {
+		logTunnelClosed(id, r.Host, false, sent, received, time.Since(start))
+	}
+}
+
+// intercept answers a CONNECT request by terminating the client's TLS with a certificate
+// for the requested host and serving the decrypted HTTP/1.1 requests through the proxy,
+// so they are logged like plain-text traffic. It returns once the connection closes.
+func (fp *forwardProxy) intercept(w http.ResponseWriter, r *http.Request, id int64, start time.Time, visible bool) {
+	host := r.Host
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = host
+	}
+	raw, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = raw.SetDeadline(time.Time{})
+	if _, err := io.WriteString(raw, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		_ = raw.Close()
+		return
+	}
+	if visible {
+		logTunnelOpen(id, host, true)
+	}
+
+	conn := newTunnelConn(raw, buffered.Reader)
+	srv := &http.Server{
+		Handler: captureInbound(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			req.URL.Scheme, req.URL.Host = "https", host
+			fp.proxy.ServeHTTP(w, req)
+		})),
+		ReadHeaderTimeout: 30 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		ConnContext:       fp.transport.Throttle.ConnContext,
+	}
+	_ = srv.Serve(&oneConnListener{conn: tls.Server(conn, fp.ca.ServerConfig(hostname))})
+	<-conn.closed
+
+	if visible {
+		logTunnelClosed(id, host, true, conn.read.Load(), conn.written.Load(), time.Since(start))
+	}
+}
+
+// tunnelConn is a hijacked client connection. Reads first drain the bufio.Reader the
+// server may already have filled; traffic in both directions is counted.
+type tunnelConn struct {
+	net.Conn
+	r       io.Reader
+	read    atomic.Int64
+	written atomic.Int64
+	once    sync.Once
+	closed  chan struct{}
+}
+
+func newTunnelConn(c net.Conn, buffered io.Reader) *tunnelConn {
+	return &tunnelConn{Conn: c, r: io.MultiReader(buffered, c), closed: make(chan struct{})}
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *tunnelConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+func (c *tunnelConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { close(c.closed) })
+	return err
+}
+
+// oneConnListener hands a single connection to http.Server.Serve. Serve returns after
+// the second Accept while the connection keeps being served.
+type oneConnListener struct {
+	conn net.Conn
+}
+
+func (l *oneConnListener) Accept() (net.Conn, error) {
+	if l.conn == nil {
+		return nil, net.ErrClosed
+	}
+	c := l.conn
+	l.conn = nil
+	return c, nil
+}
+
+func (l *oneConnListener) Close() error { return nil }
+
+func (l *oneConnListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func logTunnelOpen(id int64, host string, intercepted bool) {
+	if !*logRequests {
+		return
+	}
+	if *logFormat == formatJSONL {
+		writeJSONLTunnel(jsonlTunnel{ID: id, Host: host, Event: "open", Intercepted: intercepted})
+		return
+	}
+	line := wrapColor(fmt.Sprintf("--- TUNNEL %d OPEN %s%s ---", id, host, interceptedSuffix(intercepted)), colorReqMarker)
+	logEntry(id, fmt.Sprintf("%s %s\n\n", coloredTime(time.Now(), colorReqMarker), line))
+}
+
+func logTunnelClosed(id int64, host string, intercepted bool, sent, received int64, d time.Duration) {
+	if !*logResponses {
+		return
+	}
+	if *logFormat == formatJSONL {
+		writeJSONLTunnel(jsonlTunnel{ID: id, Host: host, Event: "closed", Intercepted: intercepted, BytesSent: sent, BytesReceived: received, DurationMs: millis(d)})
+		return
+	}
+	summary := fmt.Sprintf("--- TUNNEL %d CLOSED %s%s (%d bytes sent, %d bytes received, %s) ---",
+		id, host, interceptedSuffix(intercepted), sent, received, d.Round(time.Millisecond))
+	logEntry(id, fmt.Sprintf("%s %s\n\n", coloredTime(time.Now(), colorResMarker), wrapColor(summary, colorResMarker)))
+}
+
+func logTunnelFailed(id int64, host string, err error) {
+	if *logFormat == formatJSONL {
+		writeJSONLTunnel(jsonlTunnel{ID: id, Host: host, Event: "failed", Error: err.Error()})
+		return
+	}
+	line := wrapColor(fmt.Sprintf("--- TUNNEL %d FAILED %s: %v ---", id, host, err), colorStatus5xx)
+	logEntry(id, fmt.Sprintf("%s %s\n\n", coloredTime(time.Now(), colorResMarker), line))
+}
+
+func interceptedSuffix(intercepted bool) string {
+	if intercepted {
+		return " [intercepted]"
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwardProxyAbsoluteForm(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "origin "+r.URL.RequestURI())
+	}))
+	defer origin.Close()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "reverse "+r.URL.Path)
+	})
+	proxy := httptest.NewServer(newForwardProxy(DebugTransport{}, nil, next))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(origin.URL + "/things?id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "origin /things?id=1" {
+		t.Errorf("forwarded body = %q", body)
+	}
+	if out := buf.String(); !strings.Contains(out, "GET /things?id=1") || !strings.Contains(out, "origin /things") {
+		t.Errorf("forwarded exchange not logged:\n%s", out)
+	}
+
+	// Requests addressed to the logger itself keep using the reverse proxy.
+	resp, err = http.Get(proxy.URL + "/local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "reverse /local" {
+		t.Errorf("origin-form request body = %q", body)
+	}
+}
+
+func TestForwardProxyConnectTunnel(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secret")
+	}))
+	defer origin.Close()
+	proxy := httptest.NewServer(newForwardProxy(DebugTransport{}, nil, http.NotFoundHandler()))
+	defer proxy.Close()
+
+	tr, ok := origin.Client().Transport.(*http.Transport)
+	if !ok {
+		t.Fatal("unexpected test server transport")
+	}
+	tr = tr.Clone()
+	proxyURL, _ := url.Parse(proxy.URL)
+	tr.Proxy = http.ProxyURL(proxyURL)
+	resp, err := (&http.Client{Transport: tr}).Get(origin.URL + "/hidden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "secret" {
+		t.Errorf("tunnelled body = %q", body)
+	}
+	tr.CloseIdleConnections()
+
+	host := strings.TrimPrefix(origin.URL, "https://")
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(buf.String(), "CLOSED "+host) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "OPEN "+host) || !strings.Contains(out, "CLOSED "+host) {
+		t.Fatalf("tunnel not logged:\n%s", out)
+	}
+	if strings.Contains(out, "/hidden") {
+		t.Errorf("tunnel payload should be opaque:\n%s", out)
+	}
+	if strings.Contains(out, " 0 bytes sent") || strings.Contains(out, " 0 bytes received") {
+		t.Errorf("tunnel byte counts missing:\n%s", out)
+	}
+}
+
+func TestForwardProxyConnectFailure(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	_ = l.Close()
+
+	proxy := httptest.NewServer(newForwardProxy(DebugTransport{}, nil, http.NotFoundHandler()))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_, _ = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", closed, closed)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", resp.StatusCode)
+	}
+	if out := buf.String(); !strings.Contains(out, "TUNNEL") || !strings.Contains(out, "FAILED "+closed) {
+		t.Errorf("failed tunnel not logged:\n%s", out)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// harVersion is the HAR specification version written to the archive.
+const harVersion = "1.2"
+
+// harRecorder accumulates exchanges and writes them to a HAR 1.2 file.
+// It is safe for concurrent use.
+type harRecorder struct {
+	path    string
+	mu      sync.Mutex
+	entries []harEntry
+	dirty   bool
+}
+
+// newHARRecorder returns a recorder that writes to the given path on Flush.
+func newHARRecorder(path string) *harRecorder {
+	return &harRecorder{path: path}
+}
+
+// Add records a completed exchange. Bodies are stored decoded.
+func (h *harRecorder) Add(ex *exchange) {
+	entry := newHAREntry(ex)
+	h.mu.Lock()
+	h.entries = append(h.entries, entry)
+	h.dirty = true
+	h.mu.Unlock()
+}
+
+// Flush writes the archive to disk if new entries were added since the last flush.
+// The file is written to a temporary sibling and renamed so readers never see a partial document.
+func (h *harRecorder) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.dirty {
+		return nil
+	}
+	doc := harDocument{Log: harLog{
+		Version: harVersion,
+		Creator: harCreator{Name: "http-proxy-logger", Version: version},
+		Entries: append([]harEntry{}, h.entries...),
+	}}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), h.path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	h.dirty = false
+	return nil
+}
+
+type harDocument struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTiming   `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []harNameVal `json:"cookies"`
+	Headers     []harNameVal `json:"headers"`
+	QueryString []harNameVal `json:"queryString"`
+	PostData    *harPostData `json:"postData,omitempty"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int          `json:"status"`
+	StatusText  string       `json:"statusText"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []harNameVal `json:"cookies"`
+	Headers     []harNameVal `json:"headers"`
+	Content     harContent   `json:"content"`
+	RedirectURL string       `json:"redirectURL"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+}
+
+type harNameVal struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harContent struct {
+	Size        int    `json:"size"`
+	Compression int    `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+type harTiming struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// newHAREntry converts one exchange to a HAR entry.
+func newHAREntry(ex *exchange) harEntry {
+	req, reqBody, resp := ex.Request, ex.ReqBody, ex.Response
+	entry := harEntry{
+		StartedDateTime: ex.Start.Format(time.RFC3339Nano),
+		Time:            millis(ex.Duration()),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: harQuery(req),
+			HeadersSize: -1,
+			BodySize:    len(reqBody),
+		},
+		Response: harResponse{
+			Status:      resp.StatusCode,
+			StatusText:  strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)+" "),
+			HTTPVersion: resp.Proto,
+			Cookies:     harCookies(resp.Cookies()),
+			Headers:     harHeaders(resp.Header),
+			Content:     harContent{MimeType: resp.Header.Get("Content-Type"), Size: int(ex.RespSize)},
+			RedirectURL: resp.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    int(ex.RespSize),
+		},
+		Timings: harPhases(ex),
+		Comment: strings.Join(ex.Labels, ", "),
+	}
+	if ex.Truncated() {
+		entry.Response.Content.Comment = fmt.Sprintf("body too large to record: %d bytes", ex.RespSize)
+	} else {
+		entry.Response.Content = harBodyContent(resp.Header, ex.decodedRespBody(), ex.RespSize)
+	}
+	if len(reqBody) > 0 {
+		entry.Request.PostData = harPostBody(req.Header, ex.decodedReqBody())
+	}
+	return entry
+}
+
+// harPhases maps the traced connection phases onto HAR timings. Phases that did not
+// happen are -1 as required by the spec; HAR's connect includes the TLS handshake.
+func harPhases(ex *exchange) harTiming {
+	orNA := func(d time.Duration) float64 {
+		if d <= 0 {
+			return -1
+		}
+		return millis(d)
+	}
+	pt := ex.Timings
+	wait := pt.TTFB
+	if wait <= 0 {
+		wait = ex.Headers.Sub(ex.Start)
+	}
+	return harTiming{
+		Blocked: -1,
+		DNS:     orNA(pt.DNS),
+		Connect: orNA(pt.Connect + pt.TLS),
+		SSL:     orNA(pt.TLS),
+		Send:    0,
+		Wait:    millis(wait),
+		Receive: millis(ex.End.Sub(ex.Headers)),
+	}
+}
+
+// harBodyContent fills the HAR content object from a decoded response body and its size
+// on the wire. Bodies that are not valid UTF-8 are stored base64-encoded as allowed by the spec.
+func harBodyContent(header http.Header, decoded []byte, wireSize int64) harContent {
+	content := harContent{MimeType: header.Get("Content-Type")}
+	content.Size = len(decoded)
+	if saved := len(decoded) - int(wireSize); saved > 0 {
+		content.Compression = saved
+	}
+	if utf8.Valid(decoded) {
+		content.Text = string(decoded)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(decoded)
+		content.Encoding = bodyEncodingBase64
+	}
+	return content
+}
+
+// harPostBody fills the HAR postData object from a decoded request body. postData has no
+// encoding field, so bodies that are not valid UTF-8 are left out with a comment.
+func harPostBody(header http.Header, decoded []byte) *harPostData {
+	post := &harPostData{MimeType: header.Get("Content-Type")}
+	if utf8.Valid(decoded) {
+		post.Text = string(decoded)
+	} else {
+		post.Comment = fmt.Sprintf("binary body of %d bytes omitted", len(decoded))
+	}
+	return post
+}
+
+// harHeaders flattens headers into name/value pairs sorted by name for stable output.
+func harHeaders(h http.Header) []harNameVal {
+	out := []harNameVal{}
+	for name, values := range h {
+		for _, v := range values {
+			out = append(out, harNameVal{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func harQuery(req *http.Request) []harNameVal {
+	out := []harNameVal{}
+	for name, values := range req.URL.Query() {
+		for _, v := range values {
+			out = append(out, harNameVal{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func harCookies(cookies []*http.Cookie) []harNameVal {
+	out := make([]harNameVal, 0, len(cookies))
+	for _, c := range cookies {
+		out = append(out, harNameVal{Name: c.Name, Value: c.Value})
+	}
+	return out
+}
+
+// millis converts a duration to fractional milliseconds.
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHARBodyContent(t *testing.T) {
+	tests := []struct {
+		name            string
+		header          http.Header
+		body            []byte
+		wantText        string
+		wantEncoding    string
+		wantCompression bool
+	}{
+		{
+			name:     "plain json",
+			header:   http.Header{"Content-Type": {"application/json"}},
+			body:     []byte(`{"a":1}`),
+			wantText: `{"a":1}`,
+		},
+		{
+			name:            "gzip decoded",
+			header:          http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"gzip"}},
+			body:            compressGzip(t, []byte(strings.Repeat("abc", 100))),
+			wantText:        strings.Repeat("abc", 100),
+			wantCompression: true,
+		},
+		{
+			name:         "binary base64",
+			header:       http.Header{"Content-Type": {"image/png"}},
+			body:         []byte{0x89, 0x50, 0xff},
+			wantText:     "iVD/",
+			wantEncoding: bodyEncodingBase64,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := &exchange{Response: &http.Response{Header: tt.header}, RespBody: tt.body}
+			c := harBodyContent(tt.header, ex.decodedRespBody(), int64(len(tt.body)))
+			if c.Text != tt.wantText {
+				t.Errorf("text = %q, want %q", c.Text, tt.wantText)
+			}
+			if c.Encoding != tt.wantEncoding {
+				t.Errorf("encoding = %q, want %q", c.Encoding, tt.wantEncoding)
+			}
+			if (c.Compression > 0) != tt.wantCompression {
+				t.Errorf("compression = %d, want >0: %v", c.Compression, tt.wantCompression)
+			}
+			if c.MimeType != tt.header.Get("Content-Type") {
+				t.Errorf("mimeType = %q", c.MimeType)
+			}
+		})
+	}
+}
+
+func TestHARPostBody(t *testing.T) {
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	if post := harPostBody(header, []byte("a=1")); post.Text != "a=1" || post.Comment != "" {
+		t.Errorf("text body = %+v", post)
+	}
+	post := harPostBody(header, []byte{0x89, 0x50, 0xff})
+	if post.Text != "" || post.Comment != "binary body of 3 bytes omitted" || post.MimeType != "application/octet-stream" {
+		t.Errorf("binary body = %+v", post)
+	}
+	if _, err := json.Marshal(post); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHARRecorderFlush(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprint(w, `{"id":7}`)
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "session.har")
+	transport := DebugTransport{HAR: newHARRecorder(path)}
+
+	req, err := http.NewRequest(http.MethodPost, upstream.URL+"/items?q=1", strings.NewReader(`{"name":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if err := transport.HAR.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc harDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid HAR JSON: %v", err)
+	}
+	if doc.Log.Version != harVersion || doc.Log.Creator.Version != version {
+		t.Errorf("version = %q, creator = %+v", doc.Log.Version, doc.Log.Creator)
+	}
+	if len(doc.Log.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(doc.Log.Entries))
+	}
+	e := doc.Log.Entries[0]
+	if e.Request.Method != http.MethodPost || e.Response.Status != http.StatusCreated {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if e.Response.StatusText != "Created" {
+		t.Errorf("statusText = %q", e.Response.StatusText)
+	}
+	if e.Request.PostData == nil || e.Request.PostData.Text != `{"name":"x"}` {
+		t.Errorf("postData not recorded: %+v", e.Request.PostData)
+	}
+	if len(e.Request.QueryString) != 1 || e.Request.QueryString[0].Name != "q" {
+		t.Errorf("queryString = %+v", e.Request.QueryString)
+	}
+	if e.Response.Content.Text != `{"id":7}` {
+		t.Errorf("content text = %q", e.Response.Content.Text)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, e.StartedDateTime); err != nil {
+		t.Errorf("startedDateTime not RFC3339: %v", err)
+	}
+
+	// A second flush with no new entries leaves the file untouched.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.HAR.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected no rewrite without new entries, stat err = %v", err)
+	}
+}
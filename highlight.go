@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"html"
 	"io"
 	"sort"
 	"strconv"
@@ -34,6 +35,8 @@ const (
 	colorReqMarker = "\033[33m"
 	colorResMarker = "\033[95m"
 	colorTime      = "\033[90m"
+
+	colorEventMarker = "\033[96m"
 )
 
 func wrapColor(s, color string) string {
@@ -47,7 +50,39 @@ func coloredTime(t time.Time, color string) string {
 	return wrapColor("["+t.Format("2006/01/02 15:04:05")+"]", color)
 }
 
-func highlightJSONValue(v interface{}, indent int) string {
+// painter renders highlighted output. The same formatting logic drives the terminal, which
+// gets ANSI escapes, and the web UI, which gets HTML spans.
+type painter struct {
+	color func(s, color string) string // a token in the given color
+	text  func(s string) string        // uncolored text
+}
+
+var (
+	ansiPainter  = painter{color: wrapColor, text: func(s string) string { return s }}
+	htmlPainter  = painter{color: htmlSpan, text: html.EscapeString}
+	plainPainter = painter{color: func(s, _ string) string { return s }, text: func(s string) string { return s }}
+)
+
+// htmlSpan escapes s and wraps it in a span whose class names the ANSI color code,
+// e.g. <span class="c36">, so the web UI stylesheet mirrors the terminal palette.
+func htmlSpan(s, color string) string {
+	code := strings.TrimSuffix(strings.TrimPrefix(color, "\033["), "m")
+	return `<span class="c` + code + `">` + html.EscapeString(s) + "</span>"
+}
+
+func highlightJSON(data []byte) string { return ansiPainter.json(data) }
+
+func highlightXML(data []byte) string { return ansiPainter.xml(data) }
+
+func highlightBody(data []byte, contentType string) []byte {
+	return ansiPainter.body(data, contentType)
+}
+
+func highlightHeaders(data []byte, isRequest bool) []byte {
+	return ansiPainter.headers(data, isRequest)
+}
+
+func (p painter) jsonValue(v interface{}, indent int) string {
 	switch t := v.(type) {
 	case map[string]interface{}:
 		keys := make([]string, 0, len(t))
@@ -56,58 +91,58 @@ func highlightJSONValue(v interface{}, indent int) string {
 		}
 		sort.Strings(keys)
 		var b strings.Builder
-		b.WriteString(wrapColor("{", colorPunct) + "\n")
+		b.WriteString(p.color("{", colorPunct) + "\n")
 		indent++
 		for i, k := range keys {
 			b.WriteString(strings.Repeat("  ", indent))
-			b.WriteString(wrapColor("\""+k+"\"", colorKey))
-			b.WriteString(wrapColor(": ", colorPunct))
-			b.WriteString(highlightJSONValue(t[k], indent))
+			b.WriteString(p.color("\""+k+"\"", colorKey))
+			b.WriteString(p.color(": ", colorPunct))
+			b.WriteString(p.jsonValue(t[k], indent))
 			if i < len(keys)-1 {
-				b.WriteString(wrapColor(",", colorPunct))
+				b.WriteString(p.color(",", colorPunct))
 			}
 			b.WriteString("\n")
 		}
 		indent--
 		b.WriteString(strings.Repeat("  ", indent))
-		b.WriteString(wrapColor("}", colorPunct))
+		b.WriteString(p.color("}", colorPunct))
 		return b.String()
 	case []interface{}:
 		var b strings.Builder
-		b.WriteString(wrapColor("[", colorPunct) + "\n")
+		b.WriteString(p.color("[", colorPunct) + "\n")
 		indent++
 		for i, val := range t {
 			b.WriteString(strings.Repeat("  ", indent))
-			b.WriteString(highlightJSONValue(val, indent))
+			b.WriteString(p.jsonValue(val, indent))
 			if i < len(t)-1 {
-				b.WriteString(wrapColor(",", colorPunct))
+				b.WriteString(p.color(",", colorPunct))
 			}
 			b.WriteString("\n")
 		}
 		indent--
 		b.WriteString(strings.Repeat("  ", indent))
-		b.WriteString(wrapColor("]", colorPunct))
+		b.WriteString(p.color("]", colorPunct))
 		return b.String()
 	case string:
-		return wrapColor(strconv.Quote(t), colorString)
+		return p.color(strconv.Quote(t), colorString)
 	case float64:
-		return wrapColor(strconv.FormatFloat(t, 'f', -1, 64), colorNumber)
+		return p.color(strconv.FormatFloat(t, 'f', -1, 64), colorNumber)
 	case bool:
-		return wrapColor(strconv.FormatBool(t), colorBool)
+		return p.color(strconv.FormatBool(t), colorBool)
 	default:
-		return wrapColor("null", colorNull)
+		return p.color("null", colorNull)
 	}
 }
 
-func highlightJSON(data []byte) string {
+func (p painter) json(data []byte) string {
 	var v interface{}
 	if err := json.Unmarshal(data, &v); err != nil {
-		return string(data)
+		return p.text(string(data))
 	}
-	return highlightJSONValue(v, 0)
+	return p.jsonValue(v, 0)
 }
 
-func highlightXML(data []byte) string {
+func (p painter) xml(data []byte) string {
 	dec := xml.NewDecoder(bytes.NewReader(data))
 	var b strings.Builder
 	indent := 0
@@ -123,7 +158,7 @@ func highlightXML(data []byte) string {
 			break
 		}
 		if err != nil {
-			return string(data)
+			return p.text(string(data))
 		}
 		tokens = append(tokens, xml.CopyToken(tok))
 	}
@@ -191,18 +226,18 @@ func highlightXML(data []byte) string {
 			if !justWroteStartTag {
 				b.WriteString(strings.Repeat("  ", indent))
 			}
-			b.WriteString(wrapColor("<"+elementName, colorTag))
+			b.WriteString(p.color("<"+elementName, colorTag))
 
 			// Write namespace declarations first
 			for _, attr := range nsAttrs {
 				b.WriteString(" ")
 				if attr.Name.Space == xmlnsPrefix {
-					b.WriteString(wrapColor("xmlns:"+attr.Name.Local, colorAttr))
+					b.WriteString(p.color("xmlns:"+attr.Name.Local, colorAttr))
 				} else {
-					b.WriteString(wrapColor(xmlnsPrefix, colorAttr))
+					b.WriteString(p.color(xmlnsPrefix, colorAttr))
 				}
-				b.WriteString(wrapColor("=", colorPunct))
-				b.WriteString(wrapColor("\""+attr.Value+"\"", colorString))
+				b.WriteString(p.color("=", colorPunct))
+				b.WriteString(p.color("\""+attr.Value+"\"", colorString))
 			}
 
 			// Write regular attributes
@@ -214,11 +249,11 @@ func highlightXML(data []byte) string {
 						attrName = prefix + ":" + attr.Name.Local
 					}
 				}
-				b.WriteString(wrapColor(attrName, colorAttr))
-				b.WriteString(wrapColor("=", colorPunct))
-				b.WriteString(wrapColor("\""+attr.Value+"\"", colorString))
+				b.WriteString(p.color(attrName, colorAttr))
+				b.WriteString(p.color("=", colorPunct))
+				b.WriteString(p.color("\""+attr.Value+"\"", colorString))
 			}
-			b.WriteString(wrapColor(">", colorTag))
+			b.WriteString(p.color(">", colorTag))
 
 			if !hasSimpleText {
 				b.WriteString("\n")
@@ -239,7 +274,7 @@ func highlightXML(data []byte) string {
 			if !justWroteStartTag && !justWroteInlineText {
 				b.WriteString(strings.Repeat("  ", indent))
 			}
-			b.WriteString(wrapColor("</"+elementName+">", colorTag))
+			b.WriteString(p.color("</"+elementName+">", colorTag))
 			b.WriteString("\n")
 			justWroteStartTag = false
 			justWroteInlineText = false
@@ -249,14 +284,14 @@ func highlightXML(data []byte) string {
 			if len(txt) > 0 {
 				if justWroteStartTag {
 					// Keep text on same line as opening tag
-					written := wrapColor(txt, colorString)
+					written := p.color(txt, colorString)
 					b.WriteString(written)
 					justWroteStartTag = false
 					justWroteInlineText = true
 				} else {
 					// Multi-line or separate text content
 					b.WriteString(strings.Repeat("  ", indent))
-					b.WriteString(wrapColor(txt, colorString))
+					b.WriteString(p.color(txt, colorString))
 					b.WriteString("\n")
 					justWroteStartTag = false
 					justWroteInlineText = false
@@ -268,14 +303,14 @@ func highlightXML(data []byte) string {
 			if !justWroteStartTag {
 				b.WriteString(strings.Repeat("  ", indent))
 			}
-			b.WriteString(wrapColor("<!--"+string(tok)+"-->", colorNull))
+			b.WriteString(p.color("<!--"+string(tok)+"-->", colorNull))
 			b.WriteString("\n")
 			justWroteStartTag = false
 			justWroteInlineText = false
 
 		case xml.ProcInst:
 			// Handle XML declarations like <?xml version="1.0"?>
-			b.WriteString(wrapColor("<?"+tok.Target+" "+string(tok.Inst)+"?>", colorNull))
+			b.WriteString(p.color("<?"+tok.Target+" "+string(tok.Inst)+"?>", colorNull))
 			b.WriteString("\n")
 			justWroteStartTag = false
 			justWroteInlineText = false
@@ -284,15 +319,15 @@ func highlightXML(data []byte) string {
 	return b.String()
 }
 
-func highlightBody(data []byte, contentType string) []byte {
+func (p painter) body(data []byte, contentType string) []byte {
 	ct := strings.ToLower(contentType)
 	if strings.Contains(ct, "json") {
-		return []byte(highlightJSON(data))
+		return []byte(p.json(data))
 	}
 	if strings.Contains(ct, "xml") {
-		return []byte(highlightXML(data))
+		return []byte(p.xml(data))
 	}
-	return data
+	return []byte(p.text(string(data)))
 }
 
 func colorStatus(code int) string {
@@ -310,33 +345,32 @@ func colorStatus(code int) string {
 	}
 }
 
-func highlightHeaders(data []byte, isRequest bool) []byte {
+func (p painter) headers(data []byte, isRequest bool) []byte {
 	lines := strings.Split(string(bytes.TrimSuffix(data, []byte("\r\n"))), "\r\n")
 	if len(lines) == 0 {
 		return data
 	}
 
-	if isRequest {
-		parts := strings.SplitN(lines[0], " ", 3)
-		if len(parts) == 3 {
-			lines[0] = wrapColor(parts[0], colorMethod) + " " + wrapColor(parts[1], colorURL) + " " + parts[2]
-		}
-	} else {
-		parts := strings.SplitN(lines[0], " ", 3)
-		if len(parts) >= 2 {
-			code, _ := strconv.Atoi(parts[1])
-			status := strings.Join(parts[1:], " ")
-			lines[0] = parts[0] + " " + wrapColor(status, colorStatus(code))
-		}
+	parts := strings.SplitN(lines[0], " ", 3)
+	switch {
+	case isRequest && len(parts) == 3:
+		lines[0] = p.color(parts[0], colorMethod) + " " + p.color(parts[1], colorURL) + " " + p.text(parts[2])
+	case !isRequest && len(parts) >= 2:
+		code, _ := strconv.Atoi(parts[1])
+		status := strings.Join(parts[1:], " ")
+		lines[0] = p.text(parts[0]) + " " + p.color(status, colorStatus(code))
+	default:
+		lines[0] = p.text(lines[0])
 	}
 
+	inHeaders := true
 	for i := 1; i < len(lines); i++ {
-		if lines[i] == "" {
-			break
-		}
+		inHeaders = inHeaders && lines[i] != ""
 		kv := strings.SplitN(lines[i], ":", 2)
-		if len(kv) == 2 {
-			lines[i] = wrapColor(strings.TrimSpace(kv[0]), colorHeader) + ":" + wrapColor(kv[1], colorString)
+		if inHeaders && len(kv) == 2 {
+			lines[i] = p.color(strings.TrimSpace(kv[0]), colorHeader) + ":" + p.color(kv[1], colorString)
+		} else {
+			lines[i] = p.text(lines[i])
 		}
 	}
 	return []byte(strings.Join(lines, "\r\n"))
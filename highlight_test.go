@@ -178,3 +178,45 @@ func TestColoredTimeWithCustomColor(t *testing.T) {
 		t.Errorf("coloredTime should contain formatted time: %q", result)
 	}
 }
+
+func TestHTMLPainter(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{
+			name: "json",
+			got:  htmlPainter.json([]byte(`{"a":"<b>"}`)),
+			want: "<span class=\"c37\">{</span>\n  <span class=\"c36\">&#34;a&#34;</span><span class=\"c37\">: </span>" +
+				"<span class=\"c32\">&#34;&lt;b&gt;&#34;</span>\n<span class=\"c37\">}</span>",
+		},
+		{
+			name: "invalid json",
+			got:  htmlPainter.json([]byte(`<not json>`)),
+			want: "&lt;not json&gt;",
+		},
+		{
+			name: "xml",
+			got:  htmlPainter.xml([]byte(`<a>x &amp; y</a>`)),
+			want: "<span class=\"c34\">&lt;a</span><span class=\"c34\">&gt;</span><span class=\"c32\">x &amp; y</span>" +
+				"<span class=\"c34\">&lt;/a&gt;</span>\n",
+		},
+		{
+			name: "plain body",
+			got:  string(htmlPainter.body([]byte(`<script>`), "text/html")),
+			want: "&lt;script&gt;",
+		},
+		{
+			name: "response headers",
+			got:  string(htmlPainter.headers([]byte("HTTP/1.1 503 Service Unavailable\r\nX-Note: a<b"), false)),
+			want: "HTTP/1.1 <span class=\"c31\">503 Service Unavailable</span>\r\n" +
+				"<span class=\"c34\">X-Note</span>:<span class=\"c32\"> a&lt;b</span>",
+		},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// serverTLSConfig builds the listener TLS config from -tls-cert/-tls-key or from
+// -tls-self-signed host names. It returns nil when neither is set, i.e. plain HTTP.
+// A client CA file turns on mandatory client-certificate verification.
+func serverTLSConfig(certFile, keyFile string, selfSigned []string, clientCAFile string) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case (certFile == "") != (keyFile == ""):
+		return nil, errors.New("-tls-cert and -tls-key must be used together")
+	case certFile != "" && len(selfSigned) > 0:
+		return nil, errors.New("-tls-cert and -tls-self-signed cannot be used together")
+	case certFile != "":
+		if cert, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return nil, err
+		}
+	case len(selfSigned) > 0:
+		if cert, err = selfSignedCert(selfSigned); err != nil {
+			return nil, err
+		}
+	default:
+		if clientCAFile != "" {
+			return nil, errors.New("-tls-client-ca requires -tls-cert/-tls-key or -tls-self-signed")
+		}
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// selfSignedCert creates an in-memory certificate valid for the given host names and IPs.
+func selfSignedCert(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0], Organization: []string{"http-proxy-logger"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// certFingerprint returns the SHA-256 fingerprint of a DER certificate as colon-separated hex.
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// tlsSummary describes the client side of a TLS connection for the request log, e.g.
+// "TLS 1.3, TLS_AES_128_GCM_SHA256, SNI api.local, client CN=svc". It returns "" for plain HTTP.
+func tlsSummary(cs *tls.ConnectionState) string {
+	if cs == nil {
+		return ""
+	}
+	parts := []string{tls.VersionName(cs.Version), tls.CipherSuiteName(cs.CipherSuite)}
+	if cs.ServerName != "" {
+		parts = append(parts, "SNI "+cs.ServerName)
+	}
+	if len(cs.PeerCertificates) > 0 {
+		parts = append(parts, "client "+cs.PeerCertificates[0].Subject.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// tlsSuffix formats tlsSummary for a log marker line: " (TLS 1.3, ...)" or "".
+func tlsSuffix(cs *tls.ConnectionState) string {
+	if summary := tlsSummary(cs); summary != "" {
+		return " (" + summary + ")"
+	}
+	return ""
+}
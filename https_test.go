@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerTLSConfigValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		cert, key  string
+		selfSigned []string
+		clientCA   string
+		wantNil    bool
+	}{
+		{name: "plain http", wantNil: true},
+		{name: "cert without key", cert: "cert.pem"},
+		{name: "key without cert", key: "key.pem"},
+		{name: "cert and self-signed", cert: "cert.pem", key: "key.pem", selfSigned: []string{"localhost"}},
+		{name: "client CA without TLS", clientCA: "ca.pem"},
+		{name: "missing client CA file", selfSigned: []string{"localhost"}, clientCA: "does-not-exist.pem"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := serverTLSConfig(tt.cert, tt.key, tt.selfSigned, tt.clientCA)
+			if tt.wantNil {
+				if err != nil || cfg != nil {
+					t.Errorf("serverTLSConfig() = %v, %v; want nil, nil", cfg, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestSelfSignedCert(t *testing.T) {
+	cert, err := selfSignedCert([]string{"localhost", "127.0.0.1", "api.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	for _, host := range []string{"localhost", "127.0.0.1", "api.test"} {
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("certificate not valid for %s: %v", host, err)
+		}
+	}
+	if fp := certFingerprint(cert.Certificate[0]); len(fp) != 32*3-1 {
+		t.Errorf("unexpected fingerprint %q", fp)
+	}
+}
+
+func TestHTTPSListenerLogsTLSDetails(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	// Client CA and a client certificate it signed.
+	dir := t.TempDir()
+	caPEM, caKeyPEM, err := generateCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := parseCA(caPEM, caKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(dir, "client-ca.pem")
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	clientCert := testClientCert(t, ca, "svc-a")
+
+	cfg, err := serverTLSConfig("", "", []string{"localhost", "127.0.0.1"}, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewUnstartedServer(&httputil.ReverseProxy{
+		Transport: DebugTransport{},
+		Rewrite:   func(pr *httputil.ProxyRequest) { pr.SetURL(target) },
+	})
+	proxy.TLS = cfg
+	proxy.StartTLS()
+	defer proxy.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cfg.Certificates[0].Leaf)
+	clientTLS := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+
+	// Without a client certificate the handshake is refused.
+	noCert := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS.Clone()}}
+	if resp, err := noCert.Get(proxy.URL + "/denied"); err == nil {
+		_ = resp.Body.Close()
+		t.Error("request without a client certificate succeeded")
+	}
+
+	withCert := clientTLS.Clone()
+	withCert.Certificates = []tls.Certificate{clientCert}
+	resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: withCert}}).Get(proxy.URL + "/secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	out := buf.String()
+	for _, want := range []string{"(TLS 1.3, TLS_", "SNI localhost", "client CN=svc-a"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("request log missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "/denied") {
+		t.Errorf("rejected request was logged:\n%s", out)
+	}
+}
+
+// testClientCert issues a client-auth certificate for cn signed by ca.
+func testClientCert(t *testing.T, ca *certAuthority, cn string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// inboundRequest is a request as the client sent it, before the proxy rewrote its URL,
+// Host and headers for the upstream.
+type inboundRequest struct {
+	RemoteAddr string
+	Method     string
+	RequestURI string
+	Proto      string
+	Host       string
+	Header     http.Header
+	TLS        *tls.ConnectionState
+}
+
+type inboundKey struct{}
+
+// captureInbound records every request in its context before next handles it, so
+// DebugTransport can show it next to the request sent upstream. Without -inbound,
+// next is returned unchanged.
+func captureInbound(next http.Handler) http.Handler {
+	if !*logInbound {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in := &inboundRequest{
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			RequestURI: r.RequestURI,
+			Proto:      r.Proto,
+			Host:       r.Host,
+			Header:     r.Header.Clone(),
+			TLS:        r.TLS,
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), inboundKey{}, in)))
+	})
+}
+
+// inboundFrom returns the inbound request an outbound request was made for, or nil
+// when it was not captured.
+func inboundFrom(ctx context.Context) *inboundRequest {
+	in, _ := ctx.Value(inboundKey{}).(*inboundRequest)
+	return in
+}
+
+// head returns the request line and headers as received, in the form of an httputil dump.
+func (in *inboundRequest) head() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s %s\r\nHost: %s\r\n", in.Method, in.RequestURI, in.Proto, in.Host)
+	_ = in.Header.Write(&b)
+	return bytes.TrimSuffix(b.Bytes(), []byte("\r\n"))
+}
+
+// summary describes where the request came from for the log marker line, e.g.
+// "from 10.0.0.7:51234 (HTTP/2.0, TLS 1.3, TLS_AES_128_GCM_SHA256, SNI api.local)".
+func (in *inboundRequest) summary() string {
+	details := in.Proto
+	if tlsInfo := tlsSummary(in.TLS); tlsInfo != "" {
+		details += ", " + tlsInfo
+	}
+	return "from " + in.RemoteAddr + " (" + details + ")"
+}
+
+// inboundBlock formats the inbound request of ex against the head of the request sent
+// upstream: lines marked "-" were received but not sent like that, lines marked "+" were
+// sent instead, so changes to the Host, path and X-Forwarded-* headers stand out.
+func inboundBlock(ex *exchange, rd *redactor, sent []byte) string {
+	diff := diffOps(headLines(rd.HeaderBlock(ex.Inbound.head())), headLines(rd.HeaderBlock(sent)))
+	line := wrapColor(fmt.Sprintf("--- INBOUND %d %s ---", ex.ID, ex.Inbound.summary()), colorReqMarker)
+	return fmt.Sprintf("%s %s\n\n%s\n", coloredTime(time.Now(), colorReqMarker), line, formatDiff(diff))
+}
+
+// headLines splits a request head into its request line followed by the header lines
+// sorted by name, Host first, so heads written in different orders line up in a diff.
+// Repeated headers keep their order.
+func headLines(head []byte) []string {
+	lines := strings.Split(string(head), "\r\n")
+	fields := lines[1:]
+	key := func(line string) string {
+		name, _, _ := strings.Cut(line, ":")
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "host" {
+			return ""
+		}
+		return name
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return key(fields[i]) < key(fields[j]) })
+	return lines
+}
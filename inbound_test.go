@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeadLines(t *testing.T) {
+	head := "GET /a HTTP/1.1\r\nUser-Agent: x\r\nAccept: */*\r\nX-B: 1\r\nHost: api\r\nX-B: 2\r\naccept-language: en"
+	want := []string{"GET /a HTTP/1.1", "Host: api", "Accept: */*", "accept-language: en", "User-Agent: x", "X-B: 1", "X-B: 2"}
+	if got := headLines([]byte(head)); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("headLines = %q\nwant %q", got, want)
+	}
+}
+
+func TestInboundSummary(t *testing.T) {
+	tests := []struct {
+		in   inboundRequest
+		want string
+	}{
+		{in: inboundRequest{RemoteAddr: "10.0.0.7:51234", Proto: "HTTP/1.1"}, want: "from 10.0.0.7:51234 (HTTP/1.1)"},
+		{
+			in: inboundRequest{RemoteAddr: "[::1]:443", Proto: "HTTP/2.0", TLS: &tls.ConnectionState{
+				Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, ServerName: "api.local",
+			}},
+			want: "from [::1]:443 (HTTP/2.0, TLS 1.3, TLS_AES_128_GCM_SHA256, SNI api.local)",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.in.summary(); got != tt.want {
+			t.Errorf("summary() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestCaptureInboundDisabled(t *testing.T) {
+	next := http.NewServeMux()
+	if got := captureInbound(next); got != http.Handler(next) {
+		t.Error("handler wrapped without -inbound")
+	}
+	if in := inboundFrom(httptest.NewRequest(http.MethodGet, "/", nil).Context()); in != nil {
+		t.Errorf("inboundFrom = %+v, want nil", in)
+	}
+}
+
+func TestInboundThroughProxy(t *testing.T) {
+	originalNoColor, originalInbound := *noColor, *logInbound
+	*noColor, *logInbound = true, true
+	defer func() { *noColor, *logInbound = originalNoColor, originalInbound }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	redact, err := newRedactor(nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := DebugTransport{Redact: redact}
+	proxy := httptest.NewServer(captureInbound(&httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.URL.Path = "/v2" + pr.Out.URL.Path
+			pr.SetXForwarded()
+		},
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/users?id=1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Connection", "keep-alive")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(buf.String(), "--- RESPONSE") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := buf.String()
+	inbound, request, found := strings.Cut(out, "--- REQUEST")
+	if !found {
+		t.Fatalf("no request block:\n%s", out)
+	}
+	for _, want := range []string{
+		"--- INBOUND ",
+		" from 127.0.0.1:",
+		" (HTTP/1.1) ---",
+		"- GET /users?id=1 HTTP/1.1",
+		"+ GET /v2/users?id=1 HTTP/1.1",
+		"- Host: " + proxyURL.Host,
+		"+ Host: " + target.Host,
+		"  Authorization: [REDACTED]",
+		"- Connection: keep-alive",
+		"+ X-Forwarded-For: 127.0.0.1",
+		"+ X-Forwarded-Host: " + proxyURL.Host,
+		"+ X-Forwarded-Proto: http",
+	} {
+		if !strings.Contains(inbound, want) {
+			t.Errorf("inbound block missing %q:\n%s", want, inbound)
+		}
+	}
+	if strings.Contains(out, "secret") || !strings.Contains(request, "GET /v2/users?id=1 HTTP/1.1") {
+		t.Errorf("unexpected log:\n%s", out)
+	}
+}
+
+func TestInboundJSONL(t *testing.T) {
+	in := &inboundRequest{
+		RemoteAddr: "10.0.0.7:51234",
+		Method:     http.MethodGet,
+		RequestURI: "/users?token=abc",
+		Proto:      "HTTP/1.1",
+		Host:       "proxy.local",
+		Header:     http.Header{"Authorization": {"Bearer secret"}},
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://api.internal/users", nil)
+	rd, err := newRedactor(nil, nil, nil, []string{"token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := newJSONLRecord(rd.View(&exchange{ID: 1, Request: r, Inbound: in}))
+	if rec.Inbound == nil || rec.Inbound.Host != "proxy.local" || rec.Inbound.RemoteAddr != "10.0.0.7:51234" ||
+		rec.Inbound.URI != "/users?token=[REDACTED]" || rec.Inbound.Headers.Get("Authorization") != redactedPlaceholder {
+		t.Errorf("inbound = %+v", rec.Inbound)
+	}
+	if in.Header.Get("Authorization") != "Bearer secret" {
+		t.Error("redaction changed the captured request")
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// Supported values for the -format flag.
+const (
+	formatText  = "text"
+	formatJSONL = "jsonl"
+)
+
+// bodyEncodingBase64 marks a body that is not valid UTF-8 and was base64-encoded.
+const bodyEncodingBase64 = "base64"
+
+// jsonlRecord is a single JSON Lines entry describing one request/response exchange.
+type jsonlRecord struct {
+	ID         int64         `json:"id"`
+	Start      time.Time     `json:"start"`
+	End        time.Time     `json:"end"`
+	DurationMs float64       `json:"duration_ms"`
+	Timings    jsonlTimings  `json:"timings"`
+	Method     string        `json:"method"`
+	URL        string        `json:"url"`
+	Status     int           `json:"status"`
+	Labels     []string      `json:"labels,omitempty"`
+	Throughput float64       `json:"throughput_bps,omitempty"`
+	TLS        *jsonlTLS     `json:"tls,omitempty"`
+	Inbound    *jsonlInbound `json:"inbound,omitempty"`
+	Error      *jsonlError   `json:"error,omitempty"`
+	Request    *jsonlMessage `json:"request,omitempty"`
+	Response   *jsonlMessage `json:"response,omitempty"`
+	Curl       string        `json:"curl,omitempty"`
+	HTTPie     string        `json:"httpie,omitempty"`
+
+	RequestDiff  []string `json:"request_diff,omitempty"`
+	ResponseDiff []string `json:"response_diff,omitempty"`
+}
+
+// jsonlTimings is the upstream phase breakdown in milliseconds.
+type jsonlTimings struct {
+	DNSMs      float64 `json:"dns_ms"`
+	ConnectMs  float64 `json:"connect_ms"`
+	TLSMs      float64 `json:"tls_ms"`
+	TTFBMs     float64 `json:"ttfb_ms"`
+	TransferMs float64 `json:"transfer_ms"`
+	Reused     bool    `json:"reused,omitempty"`
+}
+
+// jsonlError describes an exchange whose upstream could not be reached.
+type jsonlError struct {
+	Class   string `json:"class"`
+	Message string `json:"message"`
+}
+
+// jsonlTLS describes the client's TLS connection to the proxy.
+type jsonlTLS struct {
+	Version    string `json:"version"`
+	Cipher     string `json:"cipher"`
+	ServerName string `json:"server_name,omitempty"`
+	ClientCert string `json:"client_cert,omitempty"`
+}
+
+// jsonlInbound describes the request as received from the client, before it was rewritten
+// for the upstream.
+type jsonlInbound struct {
+	RemoteAddr string      `json:"remote_addr"`
+	Method     string      `json:"method"`
+	URI        string      `json:"uri"`
+	Proto      string      `json:"proto"`
+	Host       string      `json:"host"`
+	TLS        *jsonlTLS   `json:"tls,omitempty"`
+	Headers    http.Header `json:"headers"`
+}
+
+// jsonlMessage holds the headers and decoded body of a request or response.
+type jsonlMessage struct {
+	Headers         http.Header `json:"headers"`
+	Body            string      `json:"body,omitempty"`
+	BodyEncoding    string      `json:"body_encoding,omitempty"`
+	ContentEncoding string      `json:"content_encoding,omitempty"`
+	Size            int64       `json:"size"`
+	Truncated       bool        `json:"truncated,omitempty"`
+}
+
+// newJSONLRecord builds a record from a finished exchange.
+// Sections disabled via -requests/-responses are omitted.
+func newJSONLRecord(ex *exchange) jsonlRecord {
+	rec := jsonlRecord{
+		ID:         ex.ID,
+		Start:      ex.Start,
+		End:        ex.End,
+		DurationMs: millis(ex.Duration()),
+		Timings: jsonlTimings{
+			DNSMs:      millis(ex.Timings.DNS),
+			ConnectMs:  millis(ex.Timings.Connect),
+			TLSMs:      millis(ex.Timings.TLS),
+			TTFBMs:     millis(ex.Timings.TTFB),
+			TransferMs: millis(ex.Timings.Transfer),
+			Reused:     ex.Timings.Reused,
+		},
+		Method: ex.Request.Method,
+		URL:    ex.Request.URL.String(),
+		Labels: ex.Labels,
+	}
+	rec.TLS = newJSONLTLS(ex.Request.TLS)
+	if *logRequests {
+		if in := ex.Inbound; in != nil {
+			rec.Inbound = &jsonlInbound{
+				RemoteAddr: in.RemoteAddr,
+				Method:     in.Method,
+				URI:        in.RequestURI,
+				Proto:      in.Proto,
+				Host:       in.Host,
+				TLS:        newJSONLTLS(in.TLS),
+				Headers:    in.Header,
+			}
+		}
+		rec.RequestDiff = ex.RequestDiff
+		rec.Request = newJSONLMessage(ex.Request.Header, ex.decodedReqBody(), int64(len(ex.ReqBody)), false)
+	}
+	if ex.Response == nil {
+		return rec
+	}
+	rec.Status = ex.Response.StatusCode
+	if ex.Throttled {
+		rec.Throughput = throughput(ex)
+	}
+	if *logResponses {
+		rec.ResponseDiff = ex.ResponseDiff
+		truncated := ex.Truncated()
+		var body []byte
+		if !truncated {
+			body = ex.decodedRespBody()
+		}
+		rec.Response = newJSONLMessage(ex.Response.Header, body, ex.RespSize, truncated)
+	}
+	return rec
+}
+
+// newJSONLTLS describes a client TLS connection, or returns nil for plain HTTP.
+func newJSONLTLS(cs *tls.ConnectionState) *jsonlTLS {
+	if cs == nil {
+		return nil
+	}
+	out := &jsonlTLS{
+		Version:    tls.VersionName(cs.Version),
+		Cipher:     tls.CipherSuiteName(cs.CipherSuite),
+		ServerName: cs.ServerName,
+	}
+	if len(cs.PeerCertificates) > 0 {
+		out.ClientCert = cs.PeerCertificates[0].Subject.String()
+	}
+	return out
+}
+
+// newJSONLMessage stores an already decoded body as text, or as base64 when it is not
+// valid UTF-8. size is the body length on the wire; truncated bodies are omitted.
+func newJSONLMessage(header http.Header, decoded []byte, size int64, truncated bool) *jsonlMessage {
+	msg := &jsonlMessage{
+		Headers:         header,
+		ContentEncoding: header.Get("Content-Encoding"),
+		Size:            size,
+	}
+	if truncated || len(decoded) > maxLogBodySize {
+		msg.Truncated = true
+		return msg
+	}
+	if utf8.Valid(decoded) {
+		msg.Body = string(decoded)
+	} else {
+		msg.Body = base64.StdEncoding.EncodeToString(decoded)
+		msg.BodyEncoding = bodyEncodingBase64
+	}
+	return msg
+}
+
+// writeJSONL emits the record as a single line. log serializes concurrent writes,
+// so lines from parallel exchanges never interleave.
+func writeJSONL(rec jsonlRecord) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("jsonl: %v", err)
+		return
+	}
+	log.Print(string(line))
+}
+
+// jsonlEvent is a JSON Lines entry for a single Server-Sent Event of a streamed response.
+type jsonlEvent struct {
+	Type     string    `json:"type"`
+	Exchange int64     `json:"exchange"`
+	Seq      int       `json:"seq"`
+	Time     time.Time `json:"time"`
+	ID       string    `json:"event_id,omitempty"`
+	Event    string    `json:"event,omitempty"`
+	Data     string    `json:"data"`
+	Retry    string    `json:"retry,omitempty"`
+}
+
+// writeJSONLEvent emits one Server-Sent Event as its own line, linked to the parent exchange.
+func writeJSONLEvent(parent int64, ev sseEvent) {
+	line, err := json.Marshal(jsonlEvent{
+		Type:     "sse_event",
+		Exchange: parent,
+		Seq:      ev.Seq,
+		Time:     time.Now(),
+		ID:       ev.ID,
+		Event:    ev.Event,
+		Data:     ev.Data,
+		Retry:    ev.Retry,
+	})
+	if err != nil {
+		log.Printf("jsonl: %v", err)
+		return
+	}
+	log.Print(string(line))
+}
+
+// jsonlFrame is a JSON Lines entry for a single WebSocket message or control frame.
+type jsonlFrame struct {
+	Type         string    `json:"type"`
+	Exchange     int64     `json:"exchange"`
+	Seq          int64     `json:"seq"`
+	Time         time.Time `json:"time"`
+	Direction    string    `json:"direction"`
+	Opcode       string    `json:"opcode"`
+	Size         int64     `json:"size"`
+	Data         string    `json:"data,omitempty"`
+	DataEncoding string    `json:"data_encoding,omitempty"`
+	CloseCode    int       `json:"close_code,omitempty"`
+	Compressed   bool      `json:"compressed,omitempty"`
+	Truncated    bool      `json:"truncated,omitempty"`
+}
+
+// writeJSONLFrame emits one WebSocket message as its own line, linked to the parent exchange.
+func writeJSONLFrame(parent, seq int64, dir string, m wsMessage) {
+	rec := jsonlFrame{
+		Type:       "ws_frame",
+		Exchange:   parent,
+		Seq:        seq,
+		Time:       time.Now(),
+		Direction:  "server_to_client",
+		Opcode:     wsOpcodeName(m.Opcode),
+		Size:       m.Size,
+		Compressed: m.Compressed,
+		Truncated:  int64(len(m.Data)) < m.Size,
+	}
+	if dir == wsClientToServer {
+		rec.Direction = "client_to_server"
+	}
+	data := m.Data
+	if m.Opcode == wsOpClose {
+		code, reason := wsCloseDetails(m.Data)
+		rec.CloseCode, data = code, []byte(reason)
+	}
+	if utf8.Valid(data) {
+		rec.Data = string(data)
+	} else {
+		rec.Data = base64.StdEncoding.EncodeToString(data)
+		rec.DataEncoding = bodyEncodingBase64
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("jsonl: %v", err)
+		return
+	}
+	log.Print(string(line))
+}
+
+// jsonlTunnel is a JSON Lines entry for a CONNECT tunnel opening, closing or failing.
+type jsonlTunnel struct {
+	Type          string    `json:"type"`
+	ID            int64     `json:"id"`
+	Time          time.Time `json:"time"`
+	Event         string    `json:"event"`
+	Host          string    `json:"host"`
+	Intercepted   bool      `json:"intercepted,omitempty"`
+	BytesSent     int64     `json:"bytes_sent,omitempty"`
+	BytesReceived int64     `json:"bytes_received,omitempty"`
+	DurationMs    float64   `json:"duration_ms,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// writeJSONLTunnel emits one tunnel event as its own line.
+func writeJSONLTunnel(rec jsonlTunnel) {
+	rec.Type, rec.Time = "tunnel", time.Now()
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("jsonl: %v", err)
+		return
+	}
+	log.Print(string(line))
+}
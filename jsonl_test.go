@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewJSONLMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       http.Header
+		body         []byte
+		wantBody     string
+		wantEncoding string
+		wantTrunc    bool
+	}{
+		{
+			name:     "plain text",
+			header:   http.Header{"Content-Type": {"text/plain"}},
+			body:     []byte("hello"),
+			wantBody: "hello",
+		},
+		{
+			name:     "gzip is decoded",
+			header:   http.Header{"Content-Encoding": {"gzip"}},
+			body:     compressGzip(t, []byte(`{"a":1}`)),
+			wantBody: `{"a":1}`,
+		},
+		{
+			name:         "binary is base64",
+			header:       http.Header{},
+			body:         []byte{0xff, 0xfe, 0x00},
+			wantBody:     base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe, 0x00}),
+			wantEncoding: bodyEncodingBase64,
+		},
+		{
+			name:      "oversized body is omitted",
+			header:    http.Header{},
+			body:      bytes.Repeat([]byte("x"), maxLogBodySize+1),
+			wantTrunc: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := &exchange{Response: &http.Response{Header: tt.header}, RespBody: tt.body, RespSize: int64(len(tt.body))}
+			msg := newJSONLMessage(tt.header, ex.decodedRespBody(), ex.RespSize, ex.Truncated())
+			if msg.Body != tt.wantBody {
+				t.Errorf("body = %q, want %q", msg.Body, tt.wantBody)
+			}
+			if msg.BodyEncoding != tt.wantEncoding {
+				t.Errorf("body_encoding = %q, want %q", msg.BodyEncoding, tt.wantEncoding)
+			}
+			if msg.Truncated != tt.wantTrunc {
+				t.Errorf("truncated = %v, want %v", msg.Truncated, tt.wantTrunc)
+			}
+			if msg.Size != int64(len(tt.body)) {
+				t.Errorf("size = %d, want %d", msg.Size, len(tt.body))
+			}
+		})
+	}
+}
+
+func TestRoundTripJSONL(t *testing.T) {
+	origFormat := *logFormat
+	*logFormat = formatJSONL
+	defer func() { *logFormat = origFormat }()
+
+	var buf bytes.Buffer
+	origFlags := log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(origFlags)
+	}()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = fmt.Fprint(w, `{"ok":true}`)
+	}))
+	defer upstream.Close()
+
+	req, err := http.NewRequest(http.MethodPost, upstream.URL+"/items?x=1", strings.NewReader(`{"name":"test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := DebugTransport{}.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != `{"ok":true}` {
+		t.Errorf("body not preserved for proxying: got %q", body)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected exactly one line, got %d: %q", len(lines), buf.String())
+	}
+	if strings.Contains(lines[0], "\033[") {
+		t.Errorf("jsonl output must not contain ANSI codes: %q", lines[0])
+	}
+
+	var rec jsonlRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("invalid JSON line: %v", err)
+	}
+	if rec.Method != http.MethodPost || rec.Status != http.StatusAccepted {
+		t.Errorf("got method %q status %d", rec.Method, rec.Status)
+	}
+	if !strings.HasSuffix(rec.URL, "/items?x=1") {
+		t.Errorf("unexpected url %q", rec.URL)
+	}
+	if rec.ID == 0 || rec.End.Before(rec.Start) {
+		t.Errorf("invalid id or timestamps: %+v", rec)
+	}
+	if rec.Request == nil || rec.Request.Body != `{"name":"test"}` {
+		t.Errorf("unexpected request section: %+v", rec.Request)
+	}
+	if rec.Response == nil || rec.Response.Body != `{"ok":true}` {
+		t.Errorf("unexpected response section: %+v", rec.Response)
+	}
+	if rec.Response.Headers.Get("Content-Type") != "application/json" {
+		t.Errorf("response headers not captured: %v", rec.Response.Headers)
+	}
+	if rec.End.Sub(rec.Start) > time.Minute {
+		t.Errorf("implausible duration %v", rec.End.Sub(rec.Start))
+	}
+}
+
+func TestRoundTripJSONLChunkedRequest(t *testing.T) {
+	origFormat := *logFormat
+	*logFormat = formatJSONL
+	defer func() { *logFormat = origFormat }()
+
+	var buf bytes.Buffer
+	origFlags := log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(origFlags)
+	}()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL, io.NopCloser(strings.NewReader("hello")))
+	req.ContentLength = -1 // sent chunked
+	resp, err := DebugTransport{}.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	var rec jsonlRecord
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("invalid JSON line %q: %v", buf.String(), err)
+	}
+	if rec.Request == nil || rec.Request.Body != "hello" || rec.Request.Size != 5 {
+		t.Errorf("chunked request section: %+v", rec.Request)
+	}
+}
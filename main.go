@@ -4,16 +4,19 @@ import (
 	"bytes"
 	"compress/gzip"
 	"compress/zlib"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
-	"net/url"
 	"os"
+	"os/signal"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/andybalholm/brotli"
@@ -21,21 +24,123 @@ import (
 
 // maxLogBodySize is the maximum response body size (in bytes) that will be highlighted in log output.
 // Bodies exceeding this limit are replaced with a truncation notice to avoid expensive formatting.
-// Note: the full response body is still buffered in memory for proxying regardless of this limit.
+// Response bodies are streamed to the client; only the first maxLogBodySize bytes are kept in memory.
 const maxLogBodySize = 1 << 20 // 1 MB
 
 // reqCounter is a global atomic counter for request/response pairs.
 var reqCounter atomic.Int64
 
+// version is the program version, set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
 // Command-line flags for controlling logging and proxy configuration.
 var logRequests = flag.Bool("requests", true, "log HTTP requests")
 var logResponses = flag.Bool("responses", true, "log HTTP responses")
 var cliTarget = flag.String("target", "", "upstream target URL (overrides TARGET)")
 var cliPort = flag.String("port", "", "listen port (overrides PORT)")
 var noColor = flag.Bool("no-color", false, "disable colored output")
+var logFormat = flag.String("format", formatText, "log output format: text or jsonl")
+var harFile = flag.String("har", "", "record exchanges into a HAR 1.2 file written on shutdown")
+var harInterval = flag.Duration("har-interval", 0, "also flush the HAR file periodically (e.g. 30s); 0 disables")
+var recordDir = flag.String("record", "", "persist every exchange as JSON into this directory")
+var replayDir = flag.String("replay", "", "answer requests from a recording directory instead of the upstream")
+var replayMatchBody = flag.Bool("replay-match-body", false, "also match the request body hash when replaying")
+var slowThreshold = flag.Duration("slow", time.Second, "responses taking at least this long are highlighted in red")
+var redactEnabled = flag.Bool("redact", true, "mask sensitive headers and configured body fields in log output")
+var redactHeaders = stringListFlag("redact-header", "additional header name to mask (repeatable)")
+var redactJSON = stringListFlag("redact-json", "JSON path to mask, e.g. $.password or $..token (repeatable)")
+var redactXML = stringListFlag("redact-xml", "XML element name whose text is masked (repeatable)")
+var redactQuery = stringListFlag("redact-query", "regex matched against query/form parameter names to mask (repeatable)")
+var filterExprs = stringListFlag("filter", "only log exchanges matching field=value or field~regex; fields: method, path, host, status, header.<Name> (repeatable)")
+var excludePaths = stringListFlag("exclude-path", "do not log requests whose path has this prefix or matches this glob (repeatable)")
+var statusFilter = stringListFlag("status", "only log responses with these statuses, e.g. 4xx,5xx or 500-599")
+var replayMiss = flag.String("replay-miss", replayMissError, "when no recording matches: error (502) or passthrough")
+var pairedOutput = flag.Bool("paired", false, "print each request together with its response as one block once the response completes")
+var logInbound = flag.Bool("inbound", false, "also log each request as received from the client (remote address, Host, protocol, TLS) and mark what changed before it was sent upstream")
+var prefixLines = flag.Bool("prefix", false, "prefix every log line with its exchange number, e.g. [12]")
+var forwardMode = flag.Bool("forward", false, "also act as a forward proxy: absolute-form requests go to their own origin and CONNECT opens tunnels")
+var mitmMode = flag.Bool("mitm", false, "with -forward, intercept CONNECT tunnels and log the decrypted HTTPS traffic")
+var caCertFile = flag.String("ca-cert", "", "CA certificate for -mitm, created if missing (default: <user config dir>/http-proxy-logger/ca.pem)")
+var caKeyFile = flag.String("ca-key", "", "CA private key for -mitm, created if missing (default: <user config dir>/http-proxy-logger/ca-key.pem)")
+var exportCA = flag.String("export-ca", "", "write the -mitm CA certificate to this file (- for stdout) and exit")
+var tlsCertFile = flag.String("tls-cert", "", "serve HTTPS with this certificate (PEM); requires -tls-key")
+var tlsKeyFile = flag.String("tls-key", "", "private key (PEM) for -tls-cert")
+var tlsSelfSigned = stringListFlag("tls-self-signed", "serve HTTPS with an in-memory self-signed certificate for these host names/IPs, e.g. localhost,127.0.0.1")
+var tlsClientCA = flag.String("tls-client-ca", "", "require client certificates signed by a CA in this PEM file (HTTPS only)")
+var upstreamCA = flag.String("upstream-ca", "", "additional CA bundle (PEM) trusted for HTTPS upstreams")
+var upstreamCert = flag.String("upstream-cert", "", "client certificate (PEM) presented to upstreams for mutual TLS; requires -upstream-key")
+var upstreamKey = flag.String("upstream-key", "", "private key (PEM) for -upstream-cert")
+var upstreamSNI = flag.String("upstream-sni", "", "server name sent and verified when connecting to HTTPS upstreams")
+var upstreamMinTLS = flag.String("upstream-min-tls", "", "minimum TLS version for upstream connections: 1.0, 1.1, 1.2 or 1.3")
+var insecureSkipVerify = flag.Bool("insecure-skip-verify", false, "do not verify upstream TLS certificates (insecure)")
+var verbose = flag.Bool("verbose", false, "log diagnostics such as the upstream certificate of each new TLS connection")
+var adminAddr = flag.String("admin", "", "serve the web UI and admin endpoints (/metrics) on this address, e.g. :9090")
+var uiBuffer = flag.Int("ui-buffer", 500, "number of recent exchanges kept for the web UI and -tui")
+var tuiMode = flag.Bool("tui", false, "show exchanges in an interactive terminal UI instead of the log")
+var printCurl = flag.Bool("curl", false, "print an equivalent curl command with each logged request")
+var printHTTPie = flag.Bool("httpie", false, "print an equivalent HTTPie command with each logged request")
+var bodyDir = flag.String("body-dir", "", "directory for binary request bodies referenced by generated commands (default: a temporary directory; the recording for -export-script)")
+var exportScript = flag.String("export-script", "", "write the requests of this recording directory as a shell script of curl commands (HTTPie with -httpie) to stdout and exit")
+var throttleUp = flag.String("throttle-up", "", "limit request bodies sent upstream per client connection, in bytes per second (e.g. 64KB, 1.5M, 750kbit)")
+var throttleDown = flag.String("throttle-down", "", "limit response bodies returned per client connection, in bytes per second")
+var throttleUpTotal = flag.String("throttle-up-total", "", "limit request bodies across all client connections, in bytes per second")
+var throttleDownTotal = flag.String("throttle-down-total", "", "limit response bodies across all client connections, in bytes per second")
+var addedRTT = flag.Duration("rtt", 0, "add this round-trip time to every forwarded request, e.g. 300ms")
+var rewriteFile = flag.String("rewrite", "", "edit headers, query parameters and bodies of matching requests and their responses with the rules in this JSON file")
+var mockFile = flag.String("mock", "", "answer requests matching the rules in this JSON file with mock responses instead of calling the upstream")
+var faultSpecs = routeListFlag("fault", "inject a fault into matching requests: <condition>[&<condition>];<action>[;<action>] with actions delay=<d>[-<d>], status=<code>[;body=<text>], reset, truncate=<bytes> and rate=<percent>, e.g. /api;delay=1s-3s;rate=20% (repeatable)")
+var routeSpecs = routeListFlag("route", "route matching requests to another upstream: <condition>[&<condition>]=<url>[;strip][;name=<name>], e.g. /api=http://api:8080;strip (repeatable)")
 
 // DebugTransport is a custom http.RoundTripper that logs requests and responses.
-type DebugTransport struct{}
+type DebugTransport struct {
+	// HAR, when set, receives every completed exchange.
+	HAR *harRecorder
+	// Recorder, when set, persists every completed exchange to disk.
+	Recorder *recorder
+	// Replay, when set, answers requests from a recording instead of the upstream.
+	Replay *replayer
+	// ReplayPassthrough forwards unmatched requests upstream instead of returning 502.
+	ReplayPassthrough bool
+	// Redact, when set, masks secrets in every output. Proxied traffic and recordings are untouched.
+	Redact *redactor
+	// Filter, when set, limits which exchanges are logged. Everything is still proxied.
+	Filter *exchangeFilter
+	// Upstream sends requests to the upstream servers. When nil, http.DefaultTransport is used.
+	Upstream http.RoundTripper
+	// Metrics, when set, aggregates every exchange for the /metrics endpoint.
+	Metrics *metrics
+	// UI, when set, keeps recent exchanges for the web UI.
+	UI *webUI
+	// TUI, when set, shows exchanges in the interactive terminal UI.
+	TUI *tui
+	// Commands, when set, adds curl/HTTPie commands repeating each logged request.
+	Commands *commandGenerator
+	// Faults, when set, injects latency, synthetic errors and dropped connections.
+	Faults *faultInjector
+	// Throttle, when set, emulates a slow link with limited bandwidth and added latency.
+	Throttle *throttle
+	// Mocks, when set, answers matching requests from -mock rules.
+	Mocks *mocker
+	// Rewrites, when set, edits matching requests before forwarding and their responses
+	// before returning them.
+	Rewrites *rewriter
+}
+
+// readRequestBody reads the body of r and puts an in-memory copy back so the request can
+// still be sent. Unlike the tail of a request dump, the result never contains the framing
+// of a chunked upload.
+func readRequestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
 
 // decodeBody decompresses the body if the encoding is gzip, deflate, or br.
 // Returns the decoded body or the original if no decoding is needed.
@@ -64,63 +169,308 @@ func decodeBody(encoding string, body []byte) ([]byte, error) {
 }
 
 // RoundTrip implements the http.RoundTripper interface.
-// It logs the outgoing request and incoming response with highlighted output.
-func (DebugTransport) RoundTrip(r *http.Request) (*http.Response, error) {
-	counter := reqCounter.Add(1)
+// It logs the outgoing request immediately (or, with -paired, holds it back to print with
+// the response) and returns the upstream response with a body that streams to the client
+// while a bounded copy is captured. The response is logged once its body has been fully
+// read or closed.
+func (t DebugTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ex := &exchange{ID: reqCounter.Add(1), Request: r, Inbound: inboundFrom(r.Context()), Start: time.Now(), Throttled: t.Throttle != nil}
+	if name := routeName(r.Context()); name != "" {
+		ex.Labels = append(ex.Labels, "route "+name)
+	}
+	fault := t.Faults.Pick(r)
+	ex.Labels = append(ex.Labels, fault.Labels()...)
 
-	requestDump, err := httputil.DumpRequestOut(r, true)
+	var err error
+	if ex.ReqBody, err = readRequestBody(r); err != nil {
+		return nil, err
+	}
+	requestDump, err := httputil.DumpRequestOut(r, false)
 	if err != nil {
 		return nil, err
 	}
-	headers := requestDump
-	var body []byte
-	if idx := bytes.Index(requestDump, []byte("\r\n\r\n")); idx != -1 {
-		headers = requestDump[:idx]
-		body = requestDump[idx+4:]
-		body = highlightBody(body, r.Header.Get("Content-Type"))
+	if requestDump, err = t.rewriteRequest(ex, requestDump); err != nil {
+		return nil, err
 	}
-	headers = append(highlightHeaders(headers, true), []byte("\r\n\r\n")...)
-	if *logRequests {
-		line := wrapColor(fmt.Sprintf("--- REQUEST %d ---", counter), colorReqMarker)
-		log.Printf("%s %s\n\n%s%s\n\n", coloredTime(time.Now(), colorReqMarker), line, string(headers), string(body))
+	r = ex.Request
+	t.Metrics.Begin()
+	sentHead := bytes.TrimSuffix(requestDump, []byte("\r\n\r\n"))
+	body := highlightBody(t.Redact.Body(r.Header.Get("Content-Type"), ex.decodedReqBody()), r.Header.Get("Content-Type"))
+	headers := append(highlightHeaders(t.Redact.HeaderBlock(sentHead), true), []byte("\r\n\r\n")...)
+	printRequest := func() {
+		if *logRequests && *logFormat != formatJSONL {
+			block := ""
+			if ex.Inbound != nil {
+				block = inboundBlock(ex, t.Redact, sentHead)
+			}
+			line := wrapColor(fmt.Sprintf("--- REQUEST %d%s%s ---", ex.ID, tlsSuffix(r.TLS), ex.labelSuffix()), colorReqMarker)
+			block += fmt.Sprintf("%s %s\n\n%s%s\n\n", coloredTime(time.Now(), colorReqMarker), line, string(headers), string(body))
+			if ex.RequestDiff != nil {
+				block += diffBlock(ex.ID, "request", ex.RequestDiff, colorReqMarker)
+			}
+			if t.Commands != nil {
+				block += t.Commands.Block(t.Redact.View(ex))
+			}
+			if *pairedOutput {
+				ex.pendingRequest = block
+				return
+			}
+			logEntry(ex.ID, block)
+		}
+	}
+
+	// Response-side filters hold the request block back until the status is known,
+	// so a request is only printed together with a response that will be printed too.
+	visible := t.Filter.MatchRequest(r)
+	holdRequest := t.Filter.NeedsResponse()
+	if visible && !holdRequest {
+		printRequest()
 	}
 
-	response, err := http.DefaultTransport.RoundTrip(r)
+	response, err := t.withFault(ex, fault)
 	if err != nil {
+		ex.End = time.Now()
+		if visible && holdRequest {
+			printRequest()
+		}
+		return nil, &upstreamError{ex: ex, visible: visible, err: err}
+	}
+	ex.Response = response
+	ex.Headers = time.Now()
+	t.rewriteResponse(ex)
+	if visible && holdRequest {
+		if visible = t.Filter.MatchResponse(response); visible {
+			printRequest()
+		}
+	}
+	ex.Hidden = !visible
+
+	if rwc, ok := response.Body.(io.ReadWriteCloser); ok && isWebSocketUpgrade(response) {
+		ex.WebSocket = true
+		if visible {
+			t.startStream(ex, "websocket")
+		}
+		response.Body = newWSConn(rwc, ex.ID, t.Redact, visible, func(frames, received int64) {
+			ex.Frames, ex.RespSize, ex.End = frames, received, time.Now()
+			ex.Timings.Transfer = ex.End.Sub(ex.Headers)
+			t.complete(ex)
+		})
+		return response, nil
+	}
+
+	upstreamBody := t.Throttle.Download(r.Context(), response.Body)
+	if isEventStream(response) {
+		// Streams stay open indefinitely and may idle longer than any timeout between events.
+		deadlinesFrom(r.Context()).Clear()
+		ex.EventStream = true
+		if visible {
+			t.startStream(ex, "event stream")
+		}
+		upstreamBody = newSSEBody(upstreamBody, func(ev sseEvent) {
+			ex.Events = ev.Seq
+			if visible && *logResponses {
+				ev.Data = string(t.Redact.JSON([]byte(ev.Data)))
+				logSSEEvent(ex.ID, ev)
+			}
+		})
+	} else {
+		// Streaming a large body may take longer than the server timeouts; they are pushed
+		// forward as long as data keeps arriving.
+		upstreamBody = deadlinesFrom(r.Context()).Track(upstreamBody)
+	}
+	response.Body = newCaptureBody(upstreamBody, maxLogBodySize, func(data []byte, size int64) {
+		ex.RespBody, ex.RespSize, ex.End = data, size, time.Now()
+		ex.Timings.Transfer = ex.End.Sub(ex.Headers)
+		t.complete(ex)
+	})
+	return response, nil
+}
+
+// upstream sends the request to the upstream server, or answers it from a matching mock
+// rule or the replay recording when those are configured.
+func (t DebugTransport) upstream(ex *exchange) (*http.Response, error) {
+	if err := t.Throttle.Delay(ex.Request.Context()); err != nil {
 		return nil, err
 	}
-	bodyBytes, err := io.ReadAll(response.Body)
+	if rule := t.Mocks.Match(ex.Request, ex.decodedReqBody()); rule != nil {
+		ex.Labels = append(ex.Labels, rule.Labels()...)
+		return rule.Respond(ex), nil
+	}
+	if t.Replay == nil {
+		return t.send(ex)
+	}
+	resp, err := t.Replay.Lookup(ex.Request, ex.ReqBody)
 	if err != nil {
 		return nil, err
 	}
-	// restore body for client
-	response.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	if resp != nil {
+		ex.Labels = append(ex.Labels, labelReplayed)
+		return resp, nil
+	}
+	if t.ReplayPassthrough {
+		return t.send(ex)
+	}
+	ex.Labels = append(ex.Labels, labelReplayed, "no match")
+	return replayMissResponse(ex.Request), nil
+}
+
+// send performs the real upstream round trip, tracing connection phases into ex.Timings.
+// With -verbose, the certificate of each newly established upstream TLS connection is logged.
+func (t DebugTransport) send(ex *exchange) (*http.Response, error) {
+	tracer := newPhaseTracer()
+	req := ex.Request.WithContext(httptrace.WithClientTrace(ex.Request.Context(), tracer.ClientTrace()))
+	req.Body = t.Throttle.Upload(req.Context(), req.Body)
+	upstream := t.Upstream
+	if upstream == nil {
+		upstream = http.DefaultTransport
+	}
+	resp, err := upstream.RoundTrip(req)
+	ex.Timings = tracer.Timings()
+	if *verbose && *logFormat == formatText && !ex.Timings.Reused && t.Filter.MatchRequest(ex.Request) {
+		logUpstreamTLS(ex.ID, resp, err)
+	}
+	return resp, err
+}
+
+// complete hands a finished exchange to the configured outputs.
+// Metrics and recordings get every exchange verbatim; the log outputs see the redacted view of
+// exchanges that passed the filters.
+func (t DebugTransport) complete(ex *exchange) {
+	t.Metrics.Observe(ex)
+	if t.Recorder != nil && recordable(ex) {
+		t.Recorder.Add(ex)
+	}
+	if ex.Hidden {
+		return
+	}
+	ex = t.Redact.View(ex)
+	if t.HAR != nil {
+		t.HAR.Add(ex)
+	}
+	t.UI.Add(ex)
+	t.TUI.Add(ex)
+	if *logFormat == formatJSONL {
+		if *logRequests || *logResponses {
+			rec := newJSONLRecord(ex)
+			if t.Commands != nil && *logRequests {
+				var err error
+				if rec.Curl, rec.HTTPie, err = t.Commands.Commands(ex); err != nil {
+					log.Printf("commands %d: %v", ex.ID, err)
+				}
+			}
+			writeJSONL(rec)
+		}
+		return
+	}
+	block := ex.pendingRequest
+	switch {
+	case !*logResponses:
+	case ex.EventStream || ex.WebSocket:
+		block += streamEndBlock(ex)
+	default:
+		block += responseBlock(ex)
+	}
+	if *logResponses && ex.ResponseDiff != nil {
+		block += diffBlock(ex.ID, "response", ex.ResponseDiff, colorResMarker)
+	}
+	if block != "" {
+		logEntry(ex.ID, block)
+	}
+}
+
+// startStream prints the response headers of a long-lived stream as soon as they arrive,
+// since the body may stay open indefinitely. In -paired mode the held request goes out
+// with them rather than waiting for the stream to close.
+func (t DebugTransport) startStream(ex *exchange, kind string) {
+	if *logFormat == formatJSONL {
+		return
+	}
+	block := ex.pendingRequest
+	ex.pendingRequest = ""
+	if *logResponses {
+		block += streamStartBlock(t.Redact.View(ex), kind)
+	}
+	if block != "" {
+		logEntry(ex.ID, block)
+	}
+}
 
-	headerDump, err := httputil.DumpResponse(response, false)
+// responseBlock formats the highlighted response block of a finished exchange.
+func responseBlock(ex *exchange) string {
+	headerDump, err := responseHeaderBlock(ex.Response)
 	if err != nil {
-		return nil, err
+		log.Printf("dump response %d: %v", ex.ID, err)
+		return ""
 	}
 
 	var decoded []byte
-	if len(bodyBytes) > maxLogBodySize {
-		decoded = []byte(fmt.Sprintf("[body too large to display: %d bytes]", len(bodyBytes)))
+	if ex.Truncated() {
+		decoded = []byte(fmt.Sprintf("[body too large to display: %d bytes]", ex.RespSize))
 	} else {
-		decoded, err = decodeBody(response.Header.Get("Content-Encoding"), bodyBytes)
-		if err != nil {
-			decoded = bodyBytes
-		}
-		decoded = highlightBody(decoded, response.Header.Get("Content-Type"))
+		decoded = highlightBody(ex.decodedRespBody(), ex.Response.Header.Get("Content-Type"))
 	}
 
-	headerDump = append(highlightHeaders(bytes.TrimSuffix(headerDump, []byte("\r\n\r\n")), false), []byte("\r\n\r\n")...)
+	return fmt.Sprintf("%s %s\n\n%s%s\n\n", coloredTime(time.Now(), colorResMarker), responseMarker(ex), string(headerDump), string(decoded))
+}
 
-	if *logResponses {
-		line := wrapColor(fmt.Sprintf("--- RESPONSE %d (%s) ---", counter, response.Status), colorResMarker)
-		log.Printf("%s %s\n\n%s%s\n\n", coloredTime(time.Now(), colorResMarker), line, string(headerDump), string(decoded))
+// responseMarker builds the "--- RESPONSE n (status) duration (phases) [labels] ---" line.
+// The total duration is colored against the -slow threshold so slow responses stand out.
+// Throttled exchanges also show the effective throughput of the body transfer.
+func responseMarker(ex *exchange) string {
+	head := wrapColor(fmt.Sprintf("--- RESPONSE %d (%s)", ex.ID, ex.Response.Status), colorResMarker)
+	duration := wrapColor(formatMillis(ex.Duration()), colorDuration(ex.Duration(), *slowThreshold))
+	tail := ""
+	if phases := ex.Timings.String(); phases != "" {
+		tail = " (" + phases + ")"
 	}
-	// restore body again for proxying
-	response.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-	return response, nil
+	if bps := throughput(ex); ex.Throttled && bps > 0 {
+		tail += " " + formatBytes(int64(bps)) + "/s"
+	}
+	tail += ex.labelSuffix() + " ---"
+	return head + " " + duration + wrapColor(tail, colorResMarker)
+}
+
+// streamStartBlock formats the response headers of a stream. kind labels the marker line.
+func streamStartBlock(ex *exchange, kind string) string {
+	headerDump, err := responseHeaderBlock(ex.Response)
+	if err != nil {
+		log.Printf("dump response %d: %v", ex.ID, err)
+		return ""
+	}
+	line := wrapColor(fmt.Sprintf("--- RESPONSE %d (%s) [%s] ---", ex.ID, ex.Response.Status, kind), colorResMarker)
+	return fmt.Sprintf("%s %s\n\n%s", coloredTime(time.Now(), colorResMarker), line, string(headerDump))
+}
+
+// streamEndBlock formats the closing marker of an event stream or WebSocket.
+func streamEndBlock(ex *exchange) string {
+	summary := fmt.Sprintf("--- STREAM %d CLOSED (%d events, %d bytes, %s) ---",
+		ex.ID, ex.Events, ex.RespSize, ex.Duration().Round(time.Millisecond))
+	if ex.WebSocket {
+		summary = fmt.Sprintf("--- WEBSOCKET %d CLOSED (%d frames, %s) ---",
+			ex.ID, ex.Frames, ex.Duration().Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%s %s\n\n", coloredTime(time.Now(), colorResMarker), wrapColor(summary, colorResMarker))
+}
+
+// responseHeaderBlock returns the highlighted status line and headers of resp.
+func responseHeaderBlock(resp *http.Response) ([]byte, error) {
+	dump, err := dumpResponseHead(resp)
+	if err != nil {
+		return nil, err
+	}
+	return append(highlightHeaders(dump, false), []byte("\r\n\r\n")...), nil
+}
+
+// dumpResponseHead returns the raw status line and headers of resp without the final blank line.
+// A shallow copy is dumped so the live body, which may still be in use by the proxy, is untouched.
+func dumpResponseHead(resp *http.Response) ([]byte, error) {
+	head := *resp
+	head.Body = http.NoBody
+	dump, err := httputil.DumpResponse(&head, false)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(dump, []byte("\r\n\r\n")), nil
 }
 
 // getEnv returns the value of the environment variable or a fallback if not set.
@@ -156,33 +506,291 @@ func main() {
 	if _, ok := os.LookupEnv("NO_COLOR"); ok {
 		*noColor = true
 	}
+	if *logFormat != formatText && *logFormat != formatJSONL {
+		log.Fatalf("invalid format %q: must be %q or %q", *logFormat, formatText, formatJSONL)
+	}
+	if *tuiMode && *logFormat != formatText {
+		log.Fatal("-tui cannot be combined with -format=jsonl")
+	}
+	if *uiBuffer < 1 {
+		log.Fatalf("invalid -ui-buffer %d: must be at least 1", *uiBuffer)
+	}
 	log.SetFlags(0)
-	rawTarget := getTarget()
-	target, err := url.Parse(rawTarget)
+	if *exportScript != "" {
+		dir := *bodyDir
+		if dir == "" {
+			dir = *exportScript
+		}
+		if err := writeCommandScript(os.Stdout, *exportScript, *printHTTPie && !*printCurl, &bodyStore{dir: dir}); err != nil {
+			log.Fatalf("export-script: %v", err)
+		}
+		return
+	}
+	var ca *certAuthority
+	if *mitmMode || *exportCA != "" {
+		certPath, keyPath := defaultCAPaths()
+		if *caCertFile != "" {
+			certPath = *caCertFile
+		}
+		if *caKeyFile != "" {
+			keyPath = *caKeyFile
+		}
+		var err error
+		if ca, err = loadOrCreateCA(certPath, keyPath); err != nil {
+			log.Fatalf("mitm: %v", err)
+		}
+		if *exportCA != "" {
+			if err := writeCACert(ca, *exportCA); err != nil {
+				log.Fatalf("export-ca: %v", err)
+			}
+			return
+		}
+		if !*forwardMode {
+			log.Fatal("-mitm requires -forward")
+		}
+	}
+	tlsConfig, err := serverTLSConfig(*tlsCertFile, *tlsKeyFile, *tlsSelfSigned, *tlsClientCA)
+	if err != nil {
+		log.Fatalf("tls: %v", err)
+	}
+	target, err := parseTarget(getTarget())
 	if err != nil {
-		log.Fatalf("invalid target URL %q: %v", rawTarget, err)
+		log.Fatal(err)
+	}
+	routes, err := newRouter(*routeSpecs, target)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *logFormat == formatText {
+		scheme := "http"
+		if tlsConfig != nil {
+			scheme = "https"
+		}
+		log.Printf("%s %s (%s) -> %s\n", coloredTime(time.Now(), colorTime), getListenAddress(), scheme, target)
+		if len(*tlsSelfSigned) > 0 {
+			log.Printf("%s self-signed certificate for %s, SHA-256 %s\n", coloredTime(time.Now(), colorTime),
+				strings.Join(*tlsSelfSigned, ", "), certFingerprint(tlsConfig.Certificates[0].Certificate[0]))
+		}
+		for _, rt := range routes.routes {
+			log.Printf("%s   %s -> %s\n", coloredTime(time.Now(), colorTime), rt.name, rt.target)
+		}
+		for _, spec := range *faultSpecs {
+			log.Printf("%s %s\n", coloredTime(time.Now(), colorTime), wrapColor("injecting fault "+spec, colorStatus4xx))
+		}
+		if *forwardMode {
+			log.Printf("%s forward proxy enabled (absolute-form requests and CONNECT)\n", coloredTime(time.Now(), colorTime))
+		}
+		if ca != nil {
+			log.Printf("%s intercepting TLS with CA %q (export it with -export-ca)\n", coloredTime(time.Now(), colorTime), ca.cert.Subject.CommonName)
+		}
+	}
+
+	transport := DebugTransport{}
+	if transport.Upstream, err = newUpstreamTransport(upstreamTLSOptions{
+		CAFile:             *upstreamCA,
+		CertFile:           *upstreamCert,
+		KeyFile:            *upstreamKey,
+		ServerName:         *upstreamSNI,
+		MinVersion:         *upstreamMinTLS,
+		InsecureSkipVerify: *insecureSkipVerify,
+	}); err != nil {
+		log.Fatalf("upstream tls: %v", err)
+	}
+	if *insecureSkipVerify && *logFormat == formatText {
+		log.Printf("%s %s\n", coloredTime(time.Now(), colorTime), wrapColor("warning: upstream TLS certificates are not verified", colorStatus4xx))
+	}
+	if *harFile != "" {
+		transport.HAR = newHARRecorder(*harFile)
+	}
+	var rates [4]int64
+	for i, s := range []string{*throttleUp, *throttleDown, *throttleUpTotal, *throttleDownTotal} {
+		if rates[i], err = parseByteRate(s); err != nil {
+			log.Fatalf("throttle: %v", err)
+		}
+	}
+	transport.Throttle = newThrottle(*addedRTT, rates[0], rates[1], rates[2], rates[3])
+	if transport.Throttle != nil && *logFormat == formatText {
+		log.Printf("%s %s\n", coloredTime(time.Now(), colorTime), wrapColor(transport.Throttle.String(), colorStatus4xx))
+	}
+	if *mockFile != "" {
+		if transport.Mocks, err = loadMocks(*mockFile); err != nil {
+			log.Fatalf("mock: %v", err)
+		}
+		if *logFormat == formatText {
+			log.Printf("%s mocking %d rules from %s\n", coloredTime(time.Now(), colorTime), len(transport.Mocks.rules), *mockFile)
+		}
+	}
+	if *rewriteFile != "" {
+		if transport.Rewrites, err = loadRewrites(*rewriteFile); err != nil {
+			log.Fatalf("rewrite: %v", err)
+		}
+		if *logFormat == formatText {
+			log.Printf("%s rewriting with %d rules from %s\n", coloredTime(time.Now(), colorTime), len(transport.Rewrites.rules), *rewriteFile)
+		}
 	}
-	if target.Scheme == "" || target.Host == "" {
-		log.Fatalf("invalid target URL %q: scheme and host are required", rawTarget)
+	if len(*faultSpecs) > 0 {
+		if transport.Faults, err = newFaultInjector(*faultSpecs); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if transport.Filter, err = newExchangeFilter(*filterExprs, *excludePaths, *statusFilter); err != nil {
+		log.Fatalf("filter: %v", err)
+	}
+	if *redactEnabled {
+		if transport.Redact, err = newRedactor(*redactHeaders, *redactJSON, *redactXML, *redactQuery); err != nil {
+			log.Fatalf("redact: %v", err)
+		}
+	}
+	if *recordDir != "" && *replayDir != "" {
+		log.Fatal("-record and -replay cannot be used together")
+	}
+	if *recordDir != "" {
+		if transport.Recorder, err = newRecorder(*recordDir); err != nil {
+			log.Fatalf("record: %v", err)
+		}
+	}
+	if *replayDir != "" {
+		if *replayMiss != replayMissError && *replayMiss != replayMissPassthrough {
+			log.Fatalf("invalid -replay-miss %q: must be %q or %q", *replayMiss, replayMissError, replayMissPassthrough)
+		}
+		if transport.Replay, err = loadReplayer(*replayDir, *replayMatchBody); err != nil {
+			log.Fatalf("replay: %v", err)
+		}
+		transport.ReplayPassthrough = *replayMiss == replayMissPassthrough
+		if *logFormat == formatText {
+			log.Printf("%s replaying %d recorded exchanges from %s\n", coloredTime(time.Now(), colorTime), transport.Replay.Len(), *replayDir)
+		}
+	}
+
+	var admin *http.Server
+	if *adminAddr != "" {
+		transport.Metrics = newMetrics()
+		transport.UI = newWebUI(*uiBuffer)
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", transport.Metrics)
+		transport.UI.Register(mux)
+		admin = &http.Server{Addr: *adminAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		admin.RegisterOnShutdown(transport.UI.Close)
+	}
+
+	bodies := &bodyStore{dir: *bodyDir}
+	if *printCurl || *printHTTPie {
+		transport.Commands = &commandGenerator{curl: *printCurl, httpie: *printHTTPie, bodies: bodies}
+	}
+	if *tuiMode {
+		transport.TUI = newTUI(*uiBuffer)
+		transport.TUI.bodies = bodies
 	}
-	log.Printf("%s %s -> %s\n", coloredTime(time.Now(), colorTime), getListenAddress(), target)
 
 	proxy := &httputil.ReverseProxy{
-		Transport: DebugTransport{},
-		Rewrite: func(pr *httputil.ProxyRequest) {
-			pr.SetURL(target)
-			pr.Out.Host = target.Host
-		},
+		Transport:    transport,
+		Rewrite:      routes.Rewrite,
+		ErrorHandler: transport.ErrorHandler,
+	}
+
+	var handler http.Handler = proxy
+	if *forwardMode {
+		handler = newForwardProxy(transport, ca, proxy)
 	}
 
 	srv := &http.Server{
 		Addr:         getListenAddress(),
-		Handler:      proxy,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 60 * time.Second,
+		Handler:      allowUpgrades(withDeadlines(captureInbound(handler), serverReadTimeout, serverWriteTimeout)),
+		ReadTimeout:  serverReadTimeout,
+		WriteTimeout: serverWriteTimeout,
 		IdleTimeout:  120 * time.Second,
+		TLSConfig:    tlsConfig,
+		ConnContext:  transport.Throttle.ConnContext,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if transport.HAR != nil && *harInterval > 0 {
+		go flushHARPeriodically(ctx, transport.HAR, *harInterval)
 	}
-	if err := srv.ListenAndServe(); err != nil {
+
+	errCh := make(chan error, 3)
+	if admin != nil {
+		go func() { errCh <- admin.ListenAndServe() }()
+		if *logFormat == formatText {
+			log.Printf("%s web UI and admin endpoints on %s (/, /metrics)\n", coloredTime(time.Now(), colorTime), *adminAddr)
+		}
+	}
+	go func() {
+		if tlsConfig != nil {
+			errCh <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		errCh <- srv.ListenAndServe()
+	}()
+	// The TUI owns the terminal while it runs, so the log is silenced until it exits.
+	tuiDone := make(chan struct{})
+	if transport.TUI != nil {
+		log.SetOutput(io.Discard)
+		go func() {
+			defer close(tuiDone)
+			err := transport.TUI.Run(ctx, os.Stdin, os.Stdout)
+			log.SetOutput(os.Stderr)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			stop()
+		}()
+	} else {
+		close(tuiDone)
+	}
+	select {
+	case err := <-errCh:
+		stop()
+		<-tuiDone
 		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	<-tuiDone
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	if admin != nil {
+		if err := admin.Shutdown(shutdownCtx); err != nil {
+			log.Printf("admin shutdown: %v", err)
+		}
+	}
+	if transport.HAR != nil {
+		if err := transport.HAR.Flush(); err != nil {
+			log.Printf("har: %v", err)
+		}
+	}
+}
+
+// allowUpgrades clears the server's read and write deadlines for protocol upgrade requests,
+// so hijacked connections such as WebSockets are not cut off by the server timeouts.
+func allowUpgrades(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			rc := http.NewResponseController(w)
+			_ = rc.SetReadDeadline(time.Time{})
+			_ = rc.SetWriteDeadline(time.Time{})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// flushHARPeriodically writes the HAR file every interval until ctx is cancelled.
+func flushHARPeriodically(ctx context.Context, har *harRecorder, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := har.Flush(); err != nil {
+				log.Printf("har: %v", err)
+			}
+		}
 	}
 }
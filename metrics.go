@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Histogram bucket upper bounds. Durations use the Prometheus client defaults.
+var (
+	durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	sizeBuckets     = []float64{100, 1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20, 100 << 20}
+)
+
+// histogram is a cumulative Prometheus histogram.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.sum += v
+	h.count++
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+}
+
+type requestKey struct{ method, status, route string }
+
+type errorKey struct{ class, route string }
+
+// metrics aggregates proxied traffic for the /metrics endpoint in the Prometheus text
+// exposition format. Every exchange is counted, including those hidden by the log filters.
+// A nil *metrics records nothing.
+type metrics struct {
+	inFlight atomic.Int64
+
+	mu       sync.Mutex
+	requests map[requestKey]uint64
+	errors   map[errorKey]uint64
+	duration map[string]*histogram // by route
+	reqSize  map[string]*histogram
+	respSize map[string]*histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[requestKey]uint64),
+		errors:   make(map[errorKey]uint64),
+		duration: make(map[string]*histogram),
+		reqSize:  make(map[string]*histogram),
+		respSize: make(map[string]*histogram),
+	}
+}
+
+// Begin marks an exchange as in flight.
+func (m *metrics) Begin() {
+	if m != nil {
+		m.inFlight.Add(1)
+	}
+}
+
+// Observe records a completed exchange.
+func (m *metrics) Observe(ex *exchange) {
+	if m == nil {
+		return
+	}
+	m.inFlight.Add(-1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	route := metricsRoute(ex)
+	m.record(ex, strconv.Itoa(ex.Response.StatusCode), route)
+	m.histogram(m.respSize, route, sizeBuckets).observe(float64(ex.RespSize))
+}
+
+// Failed records an exchange whose upstream could not be reached.
+func (m *metrics) Failed(ex *exchange, class string, status int) {
+	if m == nil {
+		return
+	}
+	m.inFlight.Add(-1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	route := metricsRoute(ex)
+	m.record(ex, strconv.Itoa(status), route)
+	m.errors[errorKey{class, route}]++
+}
+
+func (m *metrics) record(ex *exchange, status, route string) {
+	m.requests[requestKey{ex.Request.Method, status, route}]++
+	m.histogram(m.duration, route, durationBuckets).observe(ex.Duration().Seconds())
+	m.histogram(m.reqSize, route, sizeBuckets).observe(float64(len(ex.ReqBody)))
+}
+
+func (m *metrics) histogram(set map[string]*histogram, route string, bounds []float64) *histogram {
+	h, ok := set[route]
+	if !ok {
+		h = newHistogram(bounds)
+		set[route] = h
+	}
+	return h
+}
+
+func metricsRoute(ex *exchange) string {
+	if name := routeName(ex.Request.Context()); name != "" {
+		return name
+	}
+	return defaultRouteName
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.Expose(w)
+}
+
+// Expose writes every metric family with stable ordering.
+func (m *metrics) Expose(w io.Writer) error {
+	var b strings.Builder
+	m.mu.Lock()
+	writeFamily(&b, "http_proxy_requests_total", "counter", "Proxied requests by method, status and route.")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, c := keys[i], keys[j]
+		return a.route+"\x00"+a.method+"\x00"+a.status < c.route+"\x00"+c.method+"\x00"+c.status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "http_proxy_requests_total{method=%s,status=%s,route=%s} %d\n",
+			labelValue(k.method), labelValue(k.status), labelValue(k.route), m.requests[k])
+	}
+
+	writeFamily(&b, "http_proxy_upstream_errors_total", "counter", "Requests whose upstream could not be reached, by error class and route.")
+	errKeys := make([]errorKey, 0, len(m.errors))
+	for k := range m.errors {
+		errKeys = append(errKeys, k)
+	}
+	sort.Slice(errKeys, func(i, j int) bool {
+		return errKeys[i].route+"\x00"+errKeys[i].class < errKeys[j].route+"\x00"+errKeys[j].class
+	})
+	for _, k := range errKeys {
+		fmt.Fprintf(&b, "http_proxy_upstream_errors_total{class=%s,route=%s} %d\n", labelValue(k.class), labelValue(k.route), m.errors[k])
+	}
+
+	writeHistograms(&b, "http_proxy_request_duration_seconds", "Time from receiving the request until the response body completed.", m.duration)
+	writeHistograms(&b, "http_proxy_request_size_bytes", "Request body sizes.", m.reqSize)
+	writeHistograms(&b, "http_proxy_response_size_bytes", "Response body sizes.", m.respSize)
+	m.mu.Unlock()
+
+	writeFamily(&b, "http_proxy_in_flight_requests", "gauge", "Requests currently being proxied.")
+	fmt.Fprintf(&b, "http_proxy_in_flight_requests %d\n", m.inFlight.Load())
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeFamily(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistograms(b *strings.Builder, name, help string, set map[string]*histogram) {
+	writeFamily(b, name, "histogram", help)
+	routes := make([]string, 0, len(set))
+	for r := range set {
+		routes = append(routes, r)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		h := set[route]
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "%s_bucket{route=%s,le=%s} %d\n", name, labelValue(route), labelValue(formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket{route=%s,le=\"+Inf\"} %d\n", name, labelValue(route), h.count)
+		fmt.Fprintf(b, "%s_sum{route=%s} %s\n", name, labelValue(route), formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_count{route=%s} %d\n", name, labelValue(route), h.count)
+	}
+}
+
+// labelValue quotes a label value, escaping backslashes, quotes and newlines.
+func labelValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
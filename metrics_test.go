@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	h := newHistogram([]float64{1, 5, 10})
+	for _, v := range []float64{0.5, 1, 3, 7, 50} {
+		h.observe(v)
+	}
+	want := []uint64{2, 1, 1}
+	for i, c := range h.counts {
+		if c != want[i] {
+			t.Errorf("bucket le=%v = %d, want %d", h.bounds[i], c, want[i])
+		}
+	}
+	if h.count != 5 || h.sum != 61.5 {
+		t.Errorf("count, sum = %d, %v; want 5, 61.5", h.count, h.sum)
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{in: "api", want: `"api"`},
+		{in: `say "hi"`, want: `"say \"hi\""`},
+		{in: `C:\path`, want: `"C:\\path"`},
+		{in: "a\nb", want: `"a\nb"`},
+	}
+	for _, tt := range tests {
+		if got := labelValue(tt.in); got != tt.want {
+			t.Errorf("labelValue(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsExpose(t *testing.T) {
+	m := newMetrics()
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	req = req.WithContext(context.WithValue(req.Context(), routeKey{}, "orders"))
+
+	m.Begin()
+	m.Begin()
+	m.Observe(&exchange{Request: req, Start: start, End: start.Add(30 * time.Millisecond),
+		ReqBody: make([]byte, 200), RespSize: 50, Response: &http.Response{StatusCode: 201}})
+	m.Failed(&exchange{Request: req, Start: start, End: start.Add(2 * time.Second)}, "timeout", http.StatusGatewayTimeout)
+
+	var b strings.Builder
+	if err := m.Expose(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE http_proxy_requests_total counter\n",
+		`http_proxy_requests_total{method="POST",status="201",route="orders"} 1` + "\n",
+		`http_proxy_requests_total{method="POST",status="504",route="orders"} 1` + "\n",
+		`http_proxy_upstream_errors_total{class="timeout",route="orders"} 1` + "\n",
+		`http_proxy_request_duration_seconds_bucket{route="orders",le="0.05"} 1` + "\n",
+		`http_proxy_request_duration_seconds_bucket{route="orders",le="2.5"} 2` + "\n",
+		`http_proxy_request_duration_seconds_bucket{route="orders",le="+Inf"} 2` + "\n",
+		`http_proxy_request_duration_seconds_count{route="orders"} 2` + "\n",
+		`http_proxy_request_size_bytes_bucket{route="orders",le="100"} 1` + "\n",
+		`http_proxy_request_size_bytes_bucket{route="orders",le="1024"} 2` + "\n",
+		`http_proxy_request_size_bytes_sum{route="orders"} 200` + "\n",
+		`http_proxy_response_size_bytes_count{route="orders"} 1` + "\n",
+		"http_proxy_in_flight_requests 0\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer origin.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "http://" + l.Addr().String()
+	_ = l.Close()
+
+	m := newMetrics()
+	transport := DebugTransport{Metrics: m}
+	proxy := httptest.NewServer(&httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			target := origin.URL
+			if pr.In.URL.Path == "/down" {
+				target = refused
+			}
+			u, _ := url.Parse(target)
+			pr.SetURL(u)
+		},
+		ErrorHandler: transport.ErrorHandler,
+	})
+	defer proxy.Close()
+
+	for _, path := range []string{"/up", "/down"} {
+		resp, err := http.Get(proxy.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	// The exchange completes once the proxy has read the upstream body, which can trail the client.
+	deadline := time.Now().Add(2 * time.Second)
+	for m.inFlight.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		`http_proxy_requests_total{method="GET",status="200",route="default"} 1`,
+		`http_proxy_requests_total{method="GET",status="502",route="default"} 1`,
+		`http_proxy_upstream_errors_total{class="connection refused",route="default"} 1`,
+		"http_proxy_in_flight_requests 0",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsChunkedRequestSize(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	m := newMetrics()
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL, io.NopCloser(strings.NewReader("hello")))
+	req.ContentLength = -1 // sent chunked
+	resp, err := DebugTransport{Metrics: m}.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	var b strings.Builder
+	if err := m.Expose(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := `http_proxy_request_size_bytes_sum{route="default"} 5` + "\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("missing %q in:\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CA and leaf certificate lifetimes. Leaves stay under the 398-day limit browsers enforce.
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 365 * 24 * time.Hour
+	// maxLeafCache bounds the per-host certificate cache; it is reset when full.
+	maxLeafCache = 1024
+)
+
+// certAuthority is the local root CA used for TLS interception. It mints leaf
+// certificates for intercepted hosts on demand and caches them.
+type certAuthority struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// defaultCAPaths returns where the CA is kept when -ca-cert/-ca-key are not given.
+func defaultCAPaths() (certPath, keyPath string) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	dir = filepath.Join(dir, "http-proxy-logger")
+	return filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+}
+
+// loadOrCreateCA loads the CA from certPath and keyPath, generating and saving a new
+// one when neither file exists yet.
+func loadOrCreateCA(certPath, keyPath string) (*certAuthority, error) {
+	certPEM, certErr := os.ReadFile(certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	switch {
+	case errors.Is(certErr, fs.ErrNotExist) && errors.Is(keyErr, fs.ErrNotExist):
+		var err error
+		if certPEM, keyPEM, err = generateCA(); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(certPath), 0o700); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(keyPath), 0o700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+			return nil, err
+		}
+	case certErr != nil:
+		return nil, certErr
+	case keyErr != nil:
+		return nil, keyErr
+	}
+	return parseCA(certPEM, keyPEM)
+}
+
+// generateCA creates a new self-signed ECDSA root certificate and key, PEM-encoded.
+func generateCA() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "http-proxy-logger CA", Organization: []string{"http-proxy-logger"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// parseCA builds a certAuthority from PEM-encoded certificate and key.
+func parseCA(certPEM, keyPEM []byte) (*certAuthority, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("load CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("load CA: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, errors.New("load CA: certificate is not a CA")
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("load CA: unsupported private key type")
+	}
+	return &certAuthority{cert: cert, key: key, certPEM: certPEM, leaves: make(map[string]*tls.Certificate)}, nil
+}
+
+// CertPEM returns the PEM-encoded CA certificate, for installing into trust stores.
+func (ca *certAuthority) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// writeCACert writes the CA certificate to path, or to stdout when path is "-".
+func writeCACert(ca *certAuthority, path string) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(ca.CertPEM())
+		return err
+	}
+	return os.WriteFile(path, ca.CertPEM(), 0o644)
+}
+
+// Leaf returns a certificate for host signed by the CA, minting it on first use.
+func (ca *certAuthority) Leaf(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if leaf, ok := ca.leaves[host]; ok && time.Now().Before(leaf.Leaf.NotAfter) {
+		return leaf, nil
+	}
+	leaf, err := ca.mint(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ca.leaves) >= maxLeafCache {
+		clear(ca.leaves)
+	}
+	ca.leaves[host] = leaf
+	return leaf, nil
+}
+
+func (ca *certAuthority) mint(host string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	if tmpl.NotAfter.After(ca.cert.NotAfter) {
+		tmpl.NotAfter = ca.cert.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// ServerConfig returns the TLS config presented to a client that CONNECTed to host.
+// The SNI name wins when the client sends one.
+func (ca *certAuthority) ServerConfig(host string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return ca.Leaf(hello.ServerName)
+			}
+			return ca.Leaf(host)
+		},
+	}
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
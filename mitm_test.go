@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadOrCreateCA(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "ca", "ca.pem"), filepath.Join(dir, "ca", "ca-key.pem")
+
+	ca, err := loadOrCreateCA(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.cert.IsCA {
+		t.Error("generated certificate is not a CA")
+	}
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("key permissions = %o, want 600", perm)
+	}
+
+	again, err := loadOrCreateCA(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.CertPEM(), ca.CertPEM()) {
+		t.Error("existing CA was not reused")
+	}
+
+	if err := os.Remove(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadOrCreateCA(certPath, keyPath); err == nil {
+		t.Error("expected an error when only the certificate exists")
+	}
+}
+
+func TestCertAuthorityLeaf(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := loadOrCreateCA(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	for _, host := range []string{"api.example.test", "127.0.0.1"} {
+		leaf, err := ca.Leaf(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := leaf.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("leaf for %s does not verify: %v", host, err)
+		}
+		cached, _ := ca.Leaf(host)
+		if cached != leaf {
+			t.Errorf("leaf for %s was not cached", host)
+		}
+	}
+}
+
+func TestForwardProxyIntercept(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"path":"`+r.URL.Path+`"}`)
+	}))
+	defer origin.Close()
+
+	dir := t.TempDir()
+	ca, err := loadOrCreateCA(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(newForwardProxy(DebugTransport{Upstream: origin.Client().Transport}, ca, http.NotFoundHandler()))
+	defer proxy.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	proxyURL, _ := url.Parse(proxy.URL)
+	tr := &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: &tls.Config{RootCAs: roots}}
+	resp, err := (&http.Client{Transport: tr}).Get(origin.URL + "/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != `{"path":"/secret"}` {
+		t.Errorf("intercepted body = %q", body)
+	}
+	if resp.TLS == nil || resp.TLS.PeerCertificates[0].Issuer.CommonName != ca.cert.Subject.CommonName {
+		t.Error("client did not see a certificate minted by the CA")
+	}
+	tr.CloseIdleConnections()
+
+	host := strings.TrimPrefix(origin.URL, "https://")
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(buf.String(), "CLOSED "+host) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := buf.String()
+	for _, want := range []string{"OPEN " + host + " [intercepted]", "GET /secret", `"path": "/secret"`, "CLOSED " + host + " [intercepted]"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// labelMocked marks exchanges answered by a -mock rule.
+const labelMocked = "mocked"
+
+// mockRule is one entry of the -mock file, a JSON array of rules tried in order against
+// the request as forwarded upstream, i.e. after -route set its URL and Host. A rule
+// without predicates matches every request.
+type mockRule struct {
+	Name string `json:"name,omitempty"`
+	requestPredicates
+	Response mockResponse `json:"response"`
+
+	headers map[string]*template.Template
+	body    *template.Template
+	rawBody []byte // served verbatim when body is nil
+}
+
+// requestPredicates are the request conditions of -mock and -rewrite rules. Every
+// predicate that is set must hold.
+type requestPredicates struct {
+	Method  string            `json:"method,omitempty"`  // comma-separated alternatives
+	Path    string            `json:"path,omitempty"`    // prefix or glob, as for -exclude-path
+	Query   map[string]string `json:"query,omitempty"`   // parameter values; "*" for any
+	Headers map[string]string `json:"headers,omitempty"` // header values; "*" for any
+	Body    *bodyPredicates   `json:"body,omitempty"`
+
+	bodyRegexp *regexp.Regexp
+	jsonPaths  []jsonCond
+}
+
+// bodyPredicates hold conditions on the decoded request body.
+type bodyPredicates struct {
+	Contains string                 `json:"contains,omitempty"`
+	Regex    string                 `json:"regex,omitempty"`
+	JSON     map[string]interface{} `json:"json,omitempty"` // JSON path -> expected value
+}
+
+// jsonCond requires a value reached by a JSON path to equal want.
+type jsonCond struct {
+	steps []jsonPathStep
+	want  interface{}
+}
+
+// mockResponse is the answer of a rule. The inline body and header values are
+// text/template templates over mockRequest; a body_file, resolved relative to the mock
+// file, is served verbatim unless template is set.
+type mockResponse struct {
+	Status   int               `json:"status,omitempty"` // default 200
+	Headers  map[string]string `json:"headers,omitempty"`
+	Body     string            `json:"body,omitempty"`
+	BodyFile string            `json:"body_file,omitempty"`
+	Template bool              `json:"template,omitempty"`
+}
+
+// mockRequest is the data available to response templates, e.g. {{.Query.id}},
+// {{index .Segments 2}}, {{.JSON.user.name}} or {{json .Body}}. It describes the
+// request as forwarded upstream, so Host is the upstream's.
+type mockRequest struct {
+	ID       int64
+	Method   string
+	Host     string
+	Path     string
+	URL      string
+	Segments []string          // path split on "/", without the leading empty element
+	Query    map[string]string // first value of each parameter
+	Header   map[string]string // first value of each header, by canonical name
+	Body     string
+	JSON     interface{} // the body decoded as JSON, nil otherwise
+}
+
+var mockFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// mocker answers requests matching its rules without contacting the upstream.
+// A nil *mocker matches nothing.
+type mocker struct {
+	rules []*mockRule
+}
+
+// loadMocks reads and validates the rules of a -mock file.
+func loadMocks(path string) (*mocker, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path comes from the -mock flag
+	if err != nil {
+		return nil, err
+	}
+	var rules []*mockRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, rule := range rules {
+		if err := rule.compile(filepath.Dir(path)); err != nil {
+			name := rule.Name
+			if name == "" {
+				name = "#" + strconv.Itoa(i+1)
+			}
+			return nil, fmt.Errorf("%s: rule %s: %w", path, name, err)
+		}
+	}
+	return &mocker{rules: rules}, nil
+}
+
+// compile validates the rule and prepares its regexps, JSON paths and templates.
+// dir is the directory of the mock file.
+func (rule *mockRule) compile(dir string) error {
+	err := rule.requestPredicates.compile()
+	if err != nil {
+		return err
+	}
+	resp := &rule.Response
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	if resp.Status < 100 || resp.Status > 999 {
+		return fmt.Errorf("invalid status %d", resp.Status)
+	}
+	rule.headers = make(map[string]*template.Template, len(resp.Headers))
+	for name, value := range resp.Headers {
+		if rule.headers[name], err = newMockTemplate(name, value); err != nil {
+			return err
+		}
+	}
+	body := resp.Body
+	switch {
+	case resp.BodyFile != "" && resp.Body != "":
+		return fmt.Errorf("body and body_file are exclusive")
+	case resp.BodyFile != "":
+		file := resp.BodyFile
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		data, err := os.ReadFile(file) // #nosec G304 -- path comes from the user-supplied mock file
+		if err != nil {
+			return err
+		}
+		if !resp.Template {
+			rule.rawBody = data
+			return nil
+		}
+		body = string(data)
+	}
+	rule.body, err = newMockTemplate("body", body)
+	return err
+}
+
+// newMockTemplate parses a response template. Missing query parameters and headers
+// render as empty strings.
+func newMockTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(mockFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// Match returns the first rule matching the request r with the decoded body, or nil.
+func (m *mocker) Match(r *http.Request, body []byte) *mockRule {
+	if m == nil {
+		return nil
+	}
+	req := &requestBody{raw: body}
+	for _, rule := range m.rules {
+		if rule.Match(r, req) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// compile prepares the body regexp and JSON paths.
+func (p *requestPredicates) compile() error {
+	if p.Body == nil {
+		return nil
+	}
+	var err error
+	if p.Body.Regex != "" {
+		if p.bodyRegexp, err = regexp.Compile(p.Body.Regex); err != nil {
+			return err
+		}
+	}
+	for path, want := range p.Body.JSON {
+		steps, err := parseJSONPath(path)
+		if err != nil {
+			return err
+		}
+		p.jsonPaths = append(p.jsonPaths, jsonCond{steps: steps, want: want})
+	}
+	return nil
+}
+
+// requestBody is a decoded request body matched against several rules. It is parsed as
+// JSON at most once, when the first rule with JSON predicates needs it.
+type requestBody struct {
+	raw    []byte
+	doc    interface{} // nil when the body is not JSON
+	parsed bool
+}
+
+// Match reports whether the request r with the decoded body satisfies every predicate.
+func (p *requestPredicates) Match(r *http.Request, body *requestBody) bool {
+	if !p.matchRequest(r) {
+		return false
+	}
+	if len(p.jsonPaths) > 0 && !body.parsed {
+		body.parsed = true
+		if json.Unmarshal(body.raw, &body.doc) != nil {
+			body.doc = nil
+		}
+	}
+	return p.matchBody(body.raw, body.doc)
+}
+
+// matchRequest reports whether the method, path, query and headers of r satisfy the
+// predicates.
+func (p *requestPredicates) matchRequest(r *http.Request) bool {
+	if p.Method != "" && !containsFold(strings.Split(p.Method, ","), r.Method) {
+		return false
+	}
+	if p.Path != "" && !matchPathPattern(p.Path, r.URL.Path) {
+		return false
+	}
+	query := r.URL.Query()
+	for name, want := range p.Query {
+		if !matchValue(query[name], want) {
+			return false
+		}
+	}
+	for name, want := range p.Headers {
+		if !matchValue(r.Header.Values(name), want) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchBody reports whether the decoded body satisfies the body predicates. doc is the
+// body parsed as JSON, nil when it is not JSON.
+func (p *requestPredicates) matchBody(body []byte, doc interface{}) bool {
+	if p.Body == nil {
+		return true
+	}
+	if p.Body.Contains != "" && !bytes.Contains(body, []byte(p.Body.Contains)) {
+		return false
+	}
+	if p.bodyRegexp != nil && !p.bodyRegexp.Match(body) {
+		return false
+	}
+	for _, c := range p.jsonPaths {
+		found := false
+		for _, v := range selectJSONPath(doc, c.steps) {
+			found = found || reflect.DeepEqual(v, c.want)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchValue reports whether any of values equals want, or whether there is a value
+// at all when want is "*".
+func matchValue(values []string, want string) bool {
+	for _, v := range values {
+		if want == "*" || v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectJSONPath returns every value reached by steps in v.
+func selectJSONPath(v interface{}, steps []jsonPathStep) []interface{} {
+	if len(steps) == 0 {
+		return []interface{}{v}
+	}
+	step, rest := steps[0], steps[1:]
+	var out []interface{}
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for k, child := range node {
+			if step.index == -1 && (step.name == "*" || step.name == k) {
+				out = append(out, selectJSONPath(child, rest)...)
+			} else if step.recursive {
+				out = append(out, selectJSONPath(child, steps)...)
+			}
+		}
+	case []interface{}:
+		for i, child := range node {
+			switch {
+			case step.index == i || (step.name == "*" && !step.recursive):
+				out = append(out, selectJSONPath(child, rest)...)
+			case step.recursive:
+				out = append(out, selectJSONPath(child, steps)...)
+			}
+		}
+	}
+	return out
+}
+
+// Labels names the rule for the log marker lines.
+func (rule *mockRule) Labels() []string {
+	if rule.Name == "" {
+		return []string{labelMocked}
+	}
+	return []string{labelMocked, rule.Name}
+}
+
+// Respond renders the response of the rule for ex. A failing template yields a 500
+// explaining the error, so a broken mock is noticed rather than silently skipped.
+func (rule *mockRule) Respond(ex *exchange) *http.Response {
+	data := newMockRequest(ex)
+	header := http.Header{}
+	for name, tmpl := range rule.headers {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return rule.templateError(ex, err)
+		}
+		header.Set(name, b.String())
+	}
+	body := rule.rawBody
+	if rule.body != nil {
+		var b bytes.Buffer
+		if err := rule.body.Execute(&b, data); err != nil {
+			return rule.templateError(ex, err)
+		}
+		body = b.Bytes()
+	}
+	if header.Get("Content-Type") == "" && len(body) > 0 {
+		header.Set("Content-Type", http.DetectContentType(body))
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return syntheticResponse(ex.Request, rule.Response.Status, header, body)
+}
+
+func (rule *mockRule) templateError(ex *exchange, err error) *http.Response {
+	log.Printf("mock %d: %v", ex.ID, err)
+	body := []byte(fmt.Sprintf("mock template error: %v\n", err))
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return syntheticResponse(ex.Request, http.StatusInternalServerError, header, body)
+}
+
+func newMockRequest(ex *exchange) mockRequest {
+	r := ex.Request
+	body := ex.decodedReqBody()
+	data := mockRequest{
+		ID:       ex.ID,
+		Method:   r.Method,
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		URL:      r.URL.String(),
+		Segments: strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/"),
+		Query:    make(map[string]string),
+		Header:   make(map[string]string, len(r.Header)),
+		Body:     string(body),
+	}
+	for name, values := range r.URL.Query() {
+		data.Query[name] = values[0]
+	}
+	for name, values := range r.Header {
+		data.Header[http.CanonicalHeaderKey(name)] = values[0]
+	}
+	if json.Unmarshal(body, &data.JSON) != nil {
+		data.JSON = nil
+	}
+	return data
+}
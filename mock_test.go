@@ -0,0 +1,232 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeMockFile(t *testing.T, rules string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mocks.json")
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadMocksErrors(t *testing.T) {
+	tests := []struct{ name, rules, want string }{
+		{name: "syntax", rules: `[{`, want: "unexpected end"},
+		{name: "regex", rules: `[{"body": {"regex": "("}}]`, want: "rule #1"},
+		{name: "status", rules: `[{"name": "bad", "response": {"status": 42}}]`, want: "rule bad: invalid status 42"},
+		{name: "exclusive body", rules: `[{"response": {"body": "x", "body_file": "x.json"}}]`, want: "exclusive"},
+		{name: "missing file", rules: `[{"response": {"body_file": "missing.json"}}]`, want: "missing.json"},
+		{name: "json path", rules: `[{"body": {"json": {"user": 1}}}]`, want: "must start with $"},
+		{name: "template", rules: `[{"response": {"body": "{{.Query"}}]`, want: "rule #1"},
+	}
+	for _, tt := range tests {
+		_, err := loadMocks(writeMockFile(t, tt.rules))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestMockMatch(t *testing.T) {
+	m, err := loadMocks(writeMockFile(t, `[
+		{"name": "search", "method": "GET,HEAD", "path": "/search", "query": {"q": "*", "page": "2"}},
+		{"name": "tenant", "path": "/api/*", "headers": {"X-Tenant": "acme"}},
+		{"name": "admin", "method": "POST", "path": "/users", "body": {"json": {"$.role": "admin", "$.tags[*]": "vip"}}},
+		{"name": "text", "method": "POST", "body": {"contains": "hello", "regex": "^hello \\w+$"}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method, url, header, body, want string
+	}{
+		{method: "GET", url: "/search?q=go&page=2", want: "search"},
+		{method: "head", url: "/search/x?q=&page=2", want: "search"},
+		{method: "GET", url: "/search?page=2", want: ""},
+		{method: "GET", url: "/api/orders", header: "acme", want: "tenant"},
+		{method: "GET", url: "/api/orders", header: "other", want: ""},
+		{method: "GET", url: "/api/orders/1", header: "acme", want: ""},
+		{method: "POST", url: "/users", body: `{"role": "admin", "tags": ["new", "vip"]}`, want: "admin"},
+		{method: "POST", url: "/users", body: `{"role": "user", "tags": ["vip"]}`, want: ""},
+		{method: "POST", url: "/users", body: `not json`, want: ""},
+		{method: "POST", url: "/greet", body: "hello world", want: "text"},
+		{method: "POST", url: "/greet", body: "hello big world", want: ""},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(tt.method, "http://api.test"+tt.url, nil)
+		if tt.header != "" {
+			r.Header.Set("X-Tenant", tt.header)
+		}
+		got := ""
+		if rule := m.Match(r, []byte(tt.body)); rule != nil {
+			got = rule.Name
+		}
+		if got != tt.want {
+			t.Errorf("%s %s %q: matched %q, want %q", tt.method, tt.url, tt.body, got, tt.want)
+		}
+	}
+	var none *mocker
+	if none.Match(httptest.NewRequest(http.MethodGet, "/", nil), nil) != nil {
+		t.Error("nil mocker matched")
+	}
+}
+
+func TestMockRespond(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	path := writeMockFile(t, `[
+		{"path": "/users/*", "response": {
+			"status": 201,
+			"headers": {"Content-Type": "application/json", "X-Echo": "{{.Header.X_Missing}}{{index .Header \"X-Request-Id\"}}"},
+			"body": "{\"id\": \"{{index .Segments 1}}\", \"page\": \"{{.Query.page}}\", \"name\": {{json .JSON.name}}, \"raw\": {{json .Body}}}"
+		}},
+		{"path": "/static", "response": {"body_file": "fixture.bin"}},
+		{"path": "/templated", "response": {"body_file": "fixture.txt", "template": true}},
+		{"path": "/broken", "response": {"body": "{{index .Segments 5}}"}}
+	]`)
+	dir := filepath.Dir(path)
+	if err := os.WriteFile(filepath.Join(dir, "fixture.bin"), []byte("\x89PNG{{.Method}}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "fixture.txt"), []byte("method {{.Method}}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadMocks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	respond := func(method, rawURL, body string) (*http.Response, string) {
+		r, _ := http.NewRequest(method, "http://api.test"+rawURL, nil)
+		r.Header.Set("X-Request-Id", "abc")
+		ex := &exchange{ID: 1, Request: r, ReqBody: []byte(body)}
+		rule := m.Match(r, ex.ReqBody)
+		if rule == nil {
+			t.Fatalf("%s %s did not match", method, rawURL)
+		}
+		resp := rule.Respond(ex)
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	resp, body := respond(http.MethodPost, "/users/42?page=3", `{"name": "Ada \"L\""}`)
+	want := `{"id": "42", "page": "3", "name": "Ada \"L\"", "raw": "{\"name\": \"Ada \\\"L\\\"\"}"}`
+	if resp.StatusCode != http.StatusCreated || body != want {
+		t.Errorf("templated response: %d %s\nwant %s", resp.StatusCode, body, want)
+	}
+	if resp.Header.Get("X-Echo") != "abc" || resp.Header.Get("Content-Length") != strconv.Itoa(len(body)) {
+		t.Errorf("headers = %v", resp.Header)
+	}
+
+	resp, body = respond(http.MethodGet, "/static", "")
+	if body != "\x89PNG{{.Method}}" || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") == "" {
+		t.Errorf("body_file response: %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+	if _, body = respond(http.MethodPut, "/templated", ""); body != "method PUT" {
+		t.Errorf("templated body_file = %q", body)
+	}
+	if resp, body = respond(http.MethodGet, "/broken", ""); resp.StatusCode != http.StatusInternalServerError ||
+		!strings.Contains(body, "mock template error") {
+		t.Errorf("broken template: %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestMockThroughProxy(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "real "+r.URL.Path)
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	mocks, err := loadMocks(writeMockFile(t, `[{"name": "stub user", "method": "GET", "path": "/users/*",
+		"response": {"headers": {"Content-Type": "application/json"}, "body": "{\"id\":{{index .Segments 1}}}"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordings := t.TempDir()
+	rec, err := newRecorder(recordings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := DebugTransport{Mocks: mocks, Recorder: rec}
+	proxy := httptest.NewServer(&httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite:   func(pr *httputil.ProxyRequest) { pr.SetURL(target) },
+	})
+	defer proxy.Close()
+
+	get := func(path string) string {
+		resp, err := http.Get(proxy.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	if got := get("/users/7"); got != `{"id":7}` {
+		t.Errorf("mocked body = %q", got)
+	}
+	if got := get("/health"); got != "real /health" {
+		t.Errorf("unmatched request answered with %q", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for strings.Count(buf.String(), "--- RESPONSE") < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "[mocked, stub user] ---") || !strings.Contains(out, "\"id\": 7") {
+		t.Errorf("mocked exchange not labeled and highlighted:\n%s", out)
+	}
+	files, _ := os.ReadDir(recordings)
+	if len(files) != 1 {
+		t.Errorf("recorded %d exchanges, want only the real one", len(files))
+	}
+}
+
+func TestMockChunkedRequest(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	mocks, err := loadMocks(writeMockFile(t, `[{"method": "POST", "body": {"regex": "^\\{", "json": {"$.name": "ada"}},
+		"response": {"body": "{{.Body}} {{.JSON.name}}"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "http://offline.invalid/users", io.NopCloser(strings.NewReader(`{"name":"ada"}`)))
+	req.ContentLength = -1 // sent chunked
+	resp, err := DebugTransport{Mocks: mocks}.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if want := `{"name":"ada"} ada`; string(body) != want {
+		t.Errorf("mocked body = %q, want %q", body, want)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// logEntry prints one text log block belonging to exchange id. Every block goes out in a
+// single log call, so concurrent exchanges never interleave within a block. With -prefix
+// each line additionally carries the exchange number, keeping interleaved blocks greppable.
+func logEntry(id int64, block string) {
+	if *prefixLines {
+		block = prefixBlock(id, block)
+	}
+	log.Print(block)
+}
+
+// prefixBlock prepends "[id]" to every line of block, including blank separator lines.
+// The final empty line after a trailing newline is left alone.
+func prefixBlock(id int64, block string) string {
+	tag := wrapColor(fmt.Sprintf("[%d]", id), colorTime)
+	lines := strings.Split(block, "\n")
+	for i, line := range lines {
+		if i == len(lines)-1 && line == "" {
+			break
+		}
+		if line == "" || line == "\r" {
+			lines[i] = tag + line
+		} else {
+			lines[i] = tag + " " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
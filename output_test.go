@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrefixBlock(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	tests := []struct {
+		name  string
+		block string
+		want  string
+	}{
+		{"single line", "hello\n", "[7] hello\n"},
+		{"blank separators", "a\n\nb\n\n", "[7] a\n[7]\n[7] b\n[7]\n"},
+		{"crlf headers", "Host: x\r\nAccept: */*\r\n", "[7] Host: x\r\n[7] Accept: */*\r\n"},
+		{"no trailing newline", "a\nb", "[7] a\n[7] b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prefixBlock(7, tt.block); got != tt.want {
+				t.Errorf("prefixBlock() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTripPairedOutput(t *testing.T) {
+	originalNoColor, originalPaired := *noColor, *pairedOutput
+	*noColor, *pairedOutput = true, true
+	defer func() { *noColor, *pairedOutput = originalNoColor, originalPaired }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "body of "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	transport := DebugTransport{}
+	req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/first", nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); out != "" {
+		t.Fatalf("request printed before the response completed:\n%s", out)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	out := buf.String()
+	reqAt, respAt := strings.Index(out, "GET /first"), strings.Index(out, "body of /first")
+	if reqAt == -1 || respAt == -1 || reqAt > respAt {
+		t.Fatalf("expected request followed by response:\n%s", out)
+	}
+
+	// Concurrent exchanges must still come out as contiguous request/response pairs.
+	seen := len(buf.String())
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/concurrent", nil)
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	markers := regexp.MustCompile(`--- (REQUEST|RESPONSE) (\d+)`).FindAllStringSubmatch(buf.String()[seen:], -1)
+	if len(markers) != 40 {
+		t.Fatalf("got %d markers, want 40:\n%s", len(markers), buf.String()[seen:])
+	}
+	for i := 0; i < len(markers); i += 2 {
+		if markers[i][1] != "REQUEST" || markers[i+1][1] != "RESPONSE" || markers[i][2] != markers[i+1][2] {
+			t.Fatalf("markers %d-%d are not a pair: %v %v", i, i+1, markers[i][0], markers[i+1][0])
+		}
+	}
+}
+
+func TestRoundTripPrefixedOutput(t *testing.T) {
+	originalNoColor, originalPrefix := *noColor, *prefixLines
+	*noColor, *prefixLines = true, true
+	defer func() { *noColor, *prefixLines = originalNoColor, originalPrefix }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	defer log.SetFlags(log.LstdFlags)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "line one\nline two")
+	}))
+	defer upstream.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/prefixed", nil)
+	resp, err := DebugTransport{}.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	id := regexp.MustCompile(`--- REQUEST (\d+) ---`).FindStringSubmatch(buf.String())
+	if id == nil {
+		t.Fatalf("request not logged:\n%s", buf.String())
+	}
+	tag := "[" + id[1] + "]"
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if !strings.HasPrefix(line, tag) {
+			t.Errorf("line without %s prefix: %q", tag, line)
+		}
+	}
+}
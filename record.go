@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Supported values for the -replay-miss flag.
+const (
+	replayMissError       = "error"
+	replayMissPassthrough = "passthrough"
+)
+
+// labelReplayed marks exchanges answered from a recording.
+const labelReplayed = "replayed"
+
+// recordedExchange is the on-disk representation of one exchange in a recording directory.
+type recordedExchange struct {
+	ID       int64           `json:"id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Path     string          `json:"path"`
+	Query    string          `json:"query,omitempty"`
+	BodyHash string          `json:"body_sha256,omitempty"`
+	Request  recordedMessage `json:"request"`
+	Status   int             `json:"status"`
+	Response recordedMessage `json:"response"`
+}
+
+// recordedMessage holds headers and the raw body exactly as they crossed the wire.
+type recordedMessage struct {
+	Headers      http.Header `json:"headers"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+// newRecordedMessage stores body as text when it is valid UTF-8, base64 otherwise.
+func newRecordedMessage(header http.Header, body []byte) recordedMessage {
+	msg := recordedMessage{Headers: header}
+	if utf8.Valid(body) {
+		msg.Body = string(body)
+	} else {
+		msg.Body = base64.StdEncoding.EncodeToString(body)
+		msg.BodyEncoding = bodyEncodingBase64
+	}
+	return msg
+}
+
+// bytes returns the raw body.
+func (m recordedMessage) bytes() ([]byte, error) {
+	if m.BodyEncoding == bodyEncodingBase64 {
+		return base64.StdEncoding.DecodeString(m.Body)
+	}
+	return []byte(m.Body), nil
+}
+
+// bodyHash returns the hex SHA-256 of a request body, or "" for an empty body.
+func bodyHash(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordable reports whether ex shows real upstream behavior: replayed, mocked and
+// fault-injected exchanges are not recorded.
+func recordable(ex *exchange) bool {
+	return !slices.Contains(ex.Labels, labelReplayed) && !slices.Contains(ex.Labels, labelMocked) && !hasFault(ex.Labels)
+}
+
+// recorder persists every completed exchange as a JSON file in a directory.
+type recorder struct {
+	dir string
+}
+
+// newRecorder creates dir if needed and returns a recorder writing into it.
+func newRecorder(dir string) (*recorder, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &recorder{dir: dir}, nil
+}
+
+// Add writes ex to <dir>/<id>.json. Exchanges whose response body exceeded the capture
+// limit, as well as streams and WebSockets, cannot be replayed faithfully and are skipped.
+func (rec *recorder) Add(ex *exchange) {
+	if ex.Truncated() || ex.EventStream || ex.WebSocket {
+		log.Printf("record: skipping exchange %d: response cannot be replayed", ex.ID)
+		return
+	}
+	data, err := json.MarshalIndent(recordedExchange{
+		ID:       ex.ID,
+		Method:   ex.Request.Method,
+		URL:      ex.Request.URL.String(),
+		Path:     ex.Request.URL.Path,
+		Query:    ex.Request.URL.Query().Encode(),
+		BodyHash: bodyHash(ex.ReqBody),
+		Request:  newRecordedMessage(ex.Request.Header, ex.ReqBody),
+		Status:   ex.Response.StatusCode,
+		Response: newRecordedMessage(ex.Response.Header, ex.RespBody),
+	}, "", "  ")
+	if err != nil {
+		log.Printf("record: %v", err)
+		return
+	}
+	name := filepath.Join(rec.dir, fmt.Sprintf("%06d.json", ex.ID))
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		log.Printf("record: %v", err)
+	}
+}
+
+// replayer answers requests from a recording directory.
+// Recordings sharing a key are served in order; the last one repeats once exhausted.
+type replayer struct {
+	matchBody bool
+	mu        sync.Mutex
+	entries   map[string][]*recordedExchange
+	served    map[string]int
+}
+
+// loadReplayer reads every *.json recording in dir. When matchBody is set, the request
+// body hash becomes part of the lookup key.
+func loadReplayer(dir string, matchBody bool) (*replayer, error) {
+	recs, err := loadRecording(dir)
+	if err != nil {
+		return nil, err
+	}
+	rp := &replayer{
+		matchBody: matchBody,
+		entries:   make(map[string][]*recordedExchange),
+		served:    make(map[string]int),
+	}
+	for _, rec := range recs {
+		key := rp.key(rec.Method, rec.Path, rec.Query, rec.BodyHash)
+		rp.entries[key] = append(rp.entries[key], rec)
+	}
+	return rp, nil
+}
+
+// loadRecording reads every exchange of a recording directory in recording order.
+func loadRecording(dir string) ([]*recordedExchange, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	recs := make([]*recordedExchange, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path) // #nosec G304 -- path comes from the user-supplied recording directory
+		if err != nil {
+			return nil, err
+		}
+		var rec recordedExchange
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		recs = append(recs, &rec)
+	}
+	return recs, nil
+}
+
+// exchange rebuilds the recorded request as an exchange without a response.
+func (rec *recordedExchange) exchange() (*exchange, error) {
+	req, err := http.NewRequest(rec.Method, rec.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("exchange %d: %w", rec.ID, err)
+	}
+	if rec.Request.Headers != nil {
+		req.Header = rec.Request.Headers
+	}
+	body, err := rec.Request.bytes()
+	if err != nil {
+		return nil, fmt.Errorf("exchange %d: %w", rec.ID, err)
+	}
+	return &exchange{ID: rec.ID, Request: req, ReqBody: body}, nil
+}
+
+// Len returns the number of loaded recordings.
+func (rp *replayer) Len() int {
+	n := 0
+	for _, list := range rp.entries {
+		n += len(list)
+	}
+	return n
+}
+
+func (rp *replayer) key(method, path, query, hash string) string {
+	k := method + " " + path + "?" + query
+	if rp.matchBody {
+		k += " #" + hash
+	}
+	return k
+}
+
+// Lookup returns a response recorded for the request, or nil if none matches.
+func (rp *replayer) Lookup(r *http.Request, reqBody []byte) (*http.Response, error) {
+	key := rp.key(r.Method, r.URL.Path, r.URL.Query().Encode(), bodyHash(reqBody))
+	rp.mu.Lock()
+	list := rp.entries[key]
+	if len(list) == 0 {
+		rp.mu.Unlock()
+		return nil, nil
+	}
+	idx := min(rp.served[key], len(list)-1)
+	rp.served[key]++
+	rec := list[idx]
+	rp.mu.Unlock()
+
+	body, err := rec.Response.bytes()
+	if err != nil {
+		return nil, fmt.Errorf("recording %d: %w", rec.ID, err)
+	}
+	header := rec.Response.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return syntheticResponse(r, rec.Status, header, body), nil
+}
+
+// syntheticResponse builds a response that did not come from the upstream.
+func syntheticResponse(r *http.Request, status int, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
+
+// replayMissResponse is returned when -replay-miss=error and no recording matches.
+func replayMissResponse(r *http.Request) *http.Response {
+	body := []byte(fmt.Sprintf("no recorded response for %s %s\n", r.Method, strings.TrimSpace(r.URL.RequestURI())))
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return syntheticResponse(r, http.StatusBadGateway, header, body)
+}
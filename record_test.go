@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// roundTripBody performs a request through the transport and returns the status and body.
+func roundTripBody(t *testing.T, transport DebugTransport, method, url, body string) (int, string) {
+	t.Helper()
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var hits atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Upstream", "yes")
+		_, _ = fmt.Fprintf(w, "%s %s?%s body=%s n=%d", r.Method, r.URL.Path, r.URL.RawQuery, b, n)
+	}))
+
+	dir := t.TempDir()
+	rec, err := newRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recording := DebugTransport{Recorder: rec}
+	_, first := roundTripBody(t, recording, http.MethodGet, upstream.URL+"/a?y=2&x=1", "")
+	_, second := roundTripBody(t, recording, http.MethodGet, upstream.URL+"/a?x=1&y=2", "")
+	_, posted := roundTripBody(t, recording, http.MethodPost, upstream.URL+"/b", "one")
+	upstream.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 3 {
+		t.Fatalf("got %d recorded files, want 3", len(files))
+	}
+
+	t.Run("serves recordings in order without upstream", func(t *testing.T) {
+		rp, err := loadReplayer(dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rp.Len() != 3 {
+			t.Errorf("Len = %d, want 3", rp.Len())
+		}
+		replay := DebugTransport{Replay: rp}
+		// Query parameter order does not matter.
+		if _, got := roundTripBody(t, replay, http.MethodGet, "http://offline.invalid/a?x=1&y=2", ""); got != first {
+			t.Errorf("first replay = %q, want %q", got, first)
+		}
+		if _, got := roundTripBody(t, replay, http.MethodGet, "http://offline.invalid/a?x=1&y=2", ""); got != second {
+			t.Errorf("second replay = %q, want %q", got, second)
+		}
+		// Exhausted recordings keep serving the last one.
+		if _, got := roundTripBody(t, replay, http.MethodGet, "http://offline.invalid/a?x=1&y=2", ""); got != second {
+			t.Errorf("repeated replay = %q, want %q", got, second)
+		}
+		if _, got := roundTripBody(t, replay, http.MethodPost, "http://offline.invalid/b", "different"); got != posted {
+			t.Errorf("post replay without body matching = %q, want %q", got, posted)
+		}
+	})
+
+	t.Run("body hash matching", func(t *testing.T) {
+		rp, err := loadReplayer(dir, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replay := DebugTransport{Replay: rp}
+		if _, got := roundTripBody(t, replay, http.MethodPost, "http://offline.invalid/b", "one"); got != posted {
+			t.Errorf("matching body = %q, want %q", got, posted)
+		}
+		if status, _ := roundTripBody(t, replay, http.MethodPost, "http://offline.invalid/b", "two"); status != http.StatusBadGateway {
+			t.Errorf("different body status = %d, want 502", status)
+		}
+	})
+
+	t.Run("miss returns 502", func(t *testing.T) {
+		rp, err := loadReplayer(dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, body := roundTripBody(t, DebugTransport{Replay: rp}, http.MethodDelete, "http://offline.invalid/none", "")
+		if status != http.StatusBadGateway || !strings.Contains(body, "no recorded response for DELETE /none") {
+			t.Errorf("got %d %q", status, body)
+		}
+	})
+
+	t.Run("miss passes through", func(t *testing.T) {
+		live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "live")
+		}))
+		defer live.Close()
+		rp, err := loadReplayer(dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, body := roundTripBody(t, DebugTransport{Replay: rp, ReplayPassthrough: true}, http.MethodGet, live.URL+"/none", "")
+		if body != "live" {
+			t.Errorf("passthrough body = %q, want %q", body, "live")
+		}
+	})
+}
+
+func TestRecordChunkedBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "got %s", b)
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	rec, err := newRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL+"/upload", io.NopCloser(strings.NewReader("hello")))
+	req.ContentLength = -1 // sent chunked
+	resp, err := DebugTransport{Recorder: rec}.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	rp, err := loadReplayer(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, got := roundTripBody(t, DebugTransport{Replay: rp}, http.MethodPost, "http://offline.invalid/upload", "hello"); got != "got hello" {
+		t.Errorf("replay of the same payload sent unchunked = %q, want %q", got, "got hello")
+	}
+}
+
+func TestLoadReplayerInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "bad.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadReplayer(dir, false); err == nil {
+		t.Error("expected error for malformed recording")
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// redactedPlaceholder replaces every masked value in log output.
+const redactedPlaceholder = "[REDACTED]"
+
+// defaultRedactedHeaders are always masked unless redaction is disabled.
+var defaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+	"X-Csrf-Token",
+}
+
+// stringList is a repeatable string flag. Each occurrence may also hold comma-separated values.
+type stringList []string
+
+// stringListFlag defines a repeatable string flag, in the style of flag.String.
+func stringListFlag(name, usage string) *stringList {
+	s := &stringList{}
+	flag.Var(s, name, usage)
+	return s
+}
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*s = append(*s, part)
+		}
+	}
+	return nil
+}
+
+// redactor masks secrets in log output. It never touches the proxied traffic: callers
+// pass it copies of what they are about to print. A nil *redactor performs no redaction.
+type redactor struct {
+	headers map[string]bool
+	json    [][]jsonPathStep
+	xml     []*regexp.Regexp
+	query   []*regexp.Regexp
+}
+
+// newRedactor builds a redactor from the default header list plus the given header names,
+// JSON paths (e.g. "$.password", "$..token", "$.users[*].ssn"), XML element names and
+// regular expressions matched against query and form parameter names.
+func newRedactor(headers, jsonPaths, xmlElements, queryPatterns []string) (*redactor, error) {
+	rd := &redactor{headers: make(map[string]bool)}
+	for _, h := range append(append([]string{}, defaultRedactedHeaders...), headers...) {
+		rd.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, p := range jsonPaths {
+		steps, err := parseJSONPath(p)
+		if err != nil {
+			return nil, err
+		}
+		rd.json = append(rd.json, steps)
+	}
+	for _, name := range xmlElements {
+		quoted := regexp.QuoteMeta(name)
+		re := regexp.MustCompile(`(<(?:[\w.-]+:)?` + quoted + `(?:\s[^>]*)?>)[^<]*(</(?:[\w.-]+:)?` + quoted + `\s*>)`)
+		rd.xml = append(rd.xml, re)
+	}
+	for _, q := range queryPatterns {
+		re, err := regexp.Compile(q)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query pattern %q: %w", q, err)
+		}
+		rd.query = append(rd.query, re)
+	}
+	return rd, nil
+}
+
+// Header returns a copy of h with sensitive values masked.
+func (rd *redactor) Header(h http.Header) http.Header {
+	if rd == nil || h == nil {
+		return h
+	}
+	out := h.Clone()
+	for name, values := range out {
+		if rd.headers[http.CanonicalHeaderKey(name)] {
+			for i := range values {
+				values[i] = redactedPlaceholder
+			}
+		}
+	}
+	return out
+}
+
+// HeaderBlock masks a raw "request-line CRLF headers" dump as produced by httputil.
+func (rd *redactor) HeaderBlock(block []byte) []byte {
+	if rd == nil {
+		return block
+	}
+	lines := strings.Split(string(block), "\r\n")
+	if parts := strings.SplitN(lines[0], " ", 3); len(parts) == 3 && !strings.HasPrefix(parts[0], "HTTP/") {
+		parts[1] = rd.RequestURI(parts[1])
+		lines[0] = strings.Join(parts, " ")
+	}
+	for i := 1; i < len(lines); i++ {
+		name, _, ok := strings.Cut(lines[i], ":")
+		if ok && rd.headers[http.CanonicalHeaderKey(strings.TrimSpace(name))] {
+			lines[i] = name + ": " + redactedPlaceholder
+		}
+	}
+	return []byte(strings.Join(lines, "\r\n"))
+}
+
+// URL returns a copy of u with sensitive query parameter values masked.
+func (rd *redactor) URL(u *url.URL) *url.URL {
+	if rd == nil || u == nil || len(rd.query) == 0 || u.RawQuery == "" {
+		return u
+	}
+	out := *u
+	out.RawQuery = rd.params(u.RawQuery)
+	return &out
+}
+
+// RequestURI masks the query part of a request target such as "/path?token=x".
+func (rd *redactor) RequestURI(uri string) string {
+	path, query, ok := strings.Cut(uri, "?")
+	if !ok || rd == nil || len(rd.query) == 0 {
+		return uri
+	}
+	return path + "?" + rd.params(query)
+}
+
+// params masks values of URL-encoded parameters whose names match a query pattern,
+// preserving the original order and encoding of everything else.
+func (rd *redactor) params(raw string) string {
+	pairs := strings.Split(raw, "&")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		for _, re := range rd.query {
+			if re.MatchString(name) {
+				pairs[i] = key + "=" + redactedPlaceholder
+				break
+			}
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// Body masks a decoded body according to its content type: JSON paths for JSON,
+// element names for XML and parameter patterns for URL-encoded forms.
+func (rd *redactor) Body(contentType string, body []byte) []byte {
+	if rd == nil || len(body) == 0 {
+		return body
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.Contains(mediaType, "json"):
+		return rd.JSON(body)
+	case strings.Contains(mediaType, "xml"):
+		return rd.XML(body)
+	case mediaType == "application/x-www-form-urlencoded" && len(rd.query) > 0:
+		return []byte(rd.params(string(body)))
+	default:
+		return body
+	}
+}
+
+// JSON masks the configured JSON paths. Invalid JSON, or JSON without matches,
+// is returned unchanged.
+func (rd *redactor) JSON(body []byte) []byte {
+	if rd == nil || len(rd.json) == 0 {
+		return body
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return body
+	}
+	changed := false
+	for _, steps := range rd.json {
+		var hit bool
+		v, hit = replaceJSONPath(v, steps, redactedPlaceholder)
+		changed = changed || hit
+	}
+	if !changed {
+		return body
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// XML masks the text content of the configured element names, with or without a namespace prefix.
+func (rd *redactor) XML(body []byte) []byte {
+	if rd == nil {
+		return body
+	}
+	for _, re := range rd.xml {
+		body = re.ReplaceAll(body, []byte("${1}"+redactedPlaceholder+"${2}"))
+	}
+	return body
+}
+
+// View returns a copy of ex suitable for output: headers, URL and bodies are masked and
+// bodies are stored decoded. The original exchange is left untouched.
+func (rd *redactor) View(ex *exchange) *exchange {
+	if rd == nil {
+		return ex
+	}
+	view := *ex
+	view.Request = ex.Request.Clone(ex.Request.Context())
+	view.Request.Header = rd.Header(ex.Request.Header)
+	view.Request.URL = rd.URL(ex.Request.URL)
+	view.ReqBody = rd.Body(ex.Request.Header.Get("Content-Type"), ex.decodedReqBody())
+	if ex.Inbound != nil {
+		in := *ex.Inbound
+		in.Header = rd.Header(in.Header)
+		in.RequestURI = rd.RequestURI(in.RequestURI)
+		view.Inbound = &in
+	}
+	if ex.Response != nil {
+		resp := *ex.Response
+		resp.Header = rd.Header(ex.Response.Header)
+		view.Response = &resp
+		if !ex.Truncated() {
+			view.RespBody = rd.Body(ex.Response.Header.Get("Content-Type"), ex.decodedRespBody())
+		}
+	}
+	view.truncated = ex.Truncated()
+	view.decoded = true
+	return &view
+}
+
+// jsonPathStep is one segment of a parsed JSON path.
+type jsonPathStep struct {
+	name      string // object key, or "*" for any key/element
+	index     int    // array index, or -1
+	recursive bool   // ".." descendant segment
+}
+
+// parseJSONPath parses the supported JSONPath subset: $.a.b, $..a, $.a[*], $.a[0] and $.*.
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid JSON path %q: must start with $", path)
+	}
+	rest := path[1:]
+	var steps []jsonPathStep
+	for rest != "" {
+		step := jsonPathStep{index: -1}
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.recursive = true
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSON path %q: unclosed [", path)
+			}
+			inner := strings.Trim(rest[1:end], `'"`)
+			rest = rest[end+1:]
+			if n, err := strconv.Atoi(inner); err == nil {
+				step.index = n
+			} else {
+				step.name = inner
+			}
+			steps = append(steps, step)
+			continue
+		default:
+			return nil, fmt.Errorf("invalid JSON path %q at %q", path, rest)
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end == -1 {
+			end = len(rest)
+		}
+		step.name = rest[:end]
+		rest = rest[end:]
+		if step.name == "" {
+			return nil, fmt.Errorf("invalid JSON path %q: empty segment", path)
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("invalid JSON path \"$\": the whole document cannot be redacted")
+	}
+	return steps, nil
+}
+
+// replaceJSONPath replaces every value reached by steps with value and reports whether
+// any was found.
+func replaceJSONPath(v interface{}, steps []jsonPathStep, value interface{}) (interface{}, bool) {
+	if len(steps) == 0 {
+		return value, true
+	}
+	step, rest := steps[0], steps[1:]
+	hit := false
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for k, child := range node {
+			if step.index == -1 && (step.name == "*" || step.name == k) {
+				var h bool
+				node[k], h = replaceJSONPath(child, rest, value)
+				hit = hit || h
+			} else if step.recursive {
+				var h bool
+				node[k], h = replaceJSONPath(child, steps, value)
+				hit = hit || h
+			}
+		}
+	case []interface{}:
+		for i, child := range node {
+			var h bool
+			switch {
+			case step.index == i || (step.name == "*" && !step.recursive):
+				node[i], h = replaceJSONPath(child, rest, value)
+			case step.recursive:
+				node[i], h = replaceJSONPath(child, steps, value)
+			}
+			hit = hit || h
+		}
+	}
+	return v, hit
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func mustRedactor(t *testing.T, headers, jsonPaths, xmlElements, query []string) *redactor {
+	t.Helper()
+	rd, err := newRedactor(headers, jsonPaths, xmlElements, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rd
+}
+
+func TestParseJSONPath(t *testing.T) {
+	valid := []string{"$.password", "$..token", "$.users[*].ssn", "$.items[0].key", "$.*", "$['weird key']"}
+	for _, p := range valid {
+		if _, err := parseJSONPath(p); err != nil {
+			t.Errorf("parseJSONPath(%q) unexpected error: %v", p, err)
+		}
+	}
+	invalid := []string{"password", "$", "$.", "$.a[0", "$a"}
+	for _, p := range invalid {
+		if _, err := parseJSONPath(p); err == nil {
+			t.Errorf("parseJSONPath(%q) expected error", p)
+		}
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		in    string
+		want  string
+	}{
+		{
+			name:  "top-level field",
+			paths: []string{"$.password"},
+			in:    `{"user":"bob","password":"hunter2"}`,
+			want:  `{"password":"[REDACTED]","user":"bob"}`,
+		},
+		{
+			name:  "recursive descent",
+			paths: []string{"$..token"},
+			in:    `{"token":"a","nested":{"token":"b","list":[{"token":"c"}]}}`,
+			want:  `{"nested":{"list":[{"token":"[REDACTED]"}],"token":"[REDACTED]"},"token":"[REDACTED]"}`,
+		},
+		{
+			name:  "array wildcard",
+			paths: []string{"$.users[*].ssn"},
+			in:    `{"users":[{"ssn":"1","n":1},{"ssn":"2"}]}`,
+			want:  `{"users":[{"n":1,"ssn":"[REDACTED]"},{"ssn":"[REDACTED]"}]}`,
+		},
+		{
+			name:  "array index keeps big numbers intact",
+			paths: []string{"$.keys[1]"},
+			in:    `{"keys":["a","b"],"id":12345678901234567890}`,
+			want:  `{"id":12345678901234567890,"keys":["a","[REDACTED]"]}`,
+		},
+		{
+			name:  "no match leaves body untouched",
+			paths: []string{"$.secret"},
+			in:    `{ "a" : 1 }`,
+			want:  `{ "a" : 1 }`,
+		},
+		{
+			name:  "invalid json untouched",
+			paths: []string{"$.password"},
+			in:    `password=x`,
+			want:  `password=x`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rd := mustRedactor(t, nil, tt.paths, nil, nil)
+			if got := string(rd.JSON([]byte(tt.in))); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactXML(t *testing.T) {
+	rd := mustRedactor(t, nil, nil, []string{"Password"}, nil)
+	in := `<soap:Envelope><soap:Body><ns:Password attr="1">secret</ns:Password><Password>x</Password><PasswordHint>h</PasswordHint></soap:Body></soap:Envelope>`
+	want := `<soap:Envelope><soap:Body><ns:Password attr="1">[REDACTED]</ns:Password><Password>[REDACTED]</Password><PasswordHint>h</PasswordHint></soap:Body></soap:Envelope>`
+	if got := string(rd.XML([]byte(in))); got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestRedactQueryAndHeaders(t *testing.T) {
+	rd := mustRedactor(t, []string{"X-Custom-Secret"}, nil, nil, []string{"(?i)^(api_?key|token)$"})
+
+	if got := rd.RequestURI("/search?q=go&api_key=abc&Token=t"); got != "/search?q=go&api_key=[REDACTED]&Token=[REDACTED]" {
+		t.Errorf("RequestURI = %q", got)
+	}
+	u, _ := url.Parse("http://h/p?token=x&keep=1")
+	if got := rd.URL(u).String(); got != "http://h/p?token=[REDACTED]&keep=1" {
+		t.Errorf("URL = %q", got)
+	}
+	if u.RawQuery != "token=x&keep=1" {
+		t.Errorf("original URL modified: %q", u.RawQuery)
+	}
+	if got := string(rd.Body("application/x-www-form-urlencoded", []byte("user=a&token=b"))); got != "user=a&token=[REDACTED]" {
+		t.Errorf("form body = %q", got)
+	}
+
+	h := http.Header{"Authorization": {"Bearer x"}, "X-Custom-Secret": {"s"}, "Accept": {"*/*"}}
+	masked := rd.Header(h)
+	if masked.Get("Authorization") != redactedPlaceholder || masked.Get("X-Custom-Secret") != redactedPlaceholder {
+		t.Errorf("headers not masked: %v", masked)
+	}
+	if masked.Get("Accept") != "*/*" || h.Get("Authorization") != "Bearer x" {
+		t.Errorf("unexpected header changes: masked=%v original=%v", masked, h)
+	}
+
+	block := rd.HeaderBlock([]byte("GET /x?token=1 HTTP/1.1\r\nHost: h\r\ncookie: a=b"))
+	if string(block) != "GET /x?token=[REDACTED] HTTP/1.1\r\nHost: h\r\ncookie: [REDACTED]" {
+		t.Errorf("HeaderBlock = %q", block)
+	}
+}
+
+func TestNilRedactorIsNoop(t *testing.T) {
+	var rd *redactor
+	body := []byte(`{"password":"x"}`)
+	if string(rd.Body("application/json", body)) != string(body) {
+		t.Error("nil redactor changed body")
+	}
+	ex := &exchange{}
+	if rd.View(ex) != ex {
+		t.Error("nil redactor should return the exchange itself")
+	}
+}
+
+func TestRoundTripRedactsOutputOnly(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var gotAuth, gotBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		b := make([]byte, 64)
+		n, _ := r.Body.Read(b)
+		gotBody = string(b[:n])
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=abc")
+		_, _ = w.Write([]byte(`{"token":"srv-secret"}`))
+	}))
+	defer upstream.Close()
+
+	transport := DebugTransport{Redact: mustRedactor(t, nil, []string{"$.password", "$..token"}, nil, nil)}
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL, strings.NewReader(`{"password":"hunter2"}`))
+	req.Header.Set("Authorization", "Bearer top-secret")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 64)
+	n, _ := resp.Body.Read(b)
+	_ = resp.Body.Close()
+
+	if gotAuth != "Bearer top-secret" || gotBody != `{"password":"hunter2"}` {
+		t.Errorf("proxied request altered: auth=%q body=%q", gotAuth, gotBody)
+	}
+	if string(b[:n]) != `{"token":"srv-secret"}` || resp.Header.Get("Set-Cookie") != "session=abc" {
+		t.Errorf("proxied response altered: %q %v", b[:n], resp.Header)
+	}
+	out := buf.String()
+	for _, secret := range []string{"top-secret", "hunter2", "srv-secret", "session=abc"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log output leaks %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, redactedPlaceholder) {
+		t.Errorf("placeholder missing from output:\n%s", out)
+	}
+}
+
+func TestRoundTripRedactsEncodedRequestBody(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = io.WriteString(zw, `{"user":"ada","password":"hunter2"}`)
+	_ = zw.Close()
+
+	transport := DebugTransport{Redact: mustRedactor(t, nil, []string{"$.password"}, nil, nil)}
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL, bytes.NewReader(gz.Bytes()))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	out := buf.String()
+	if strings.Contains(out, "hunter2") || !strings.Contains(out, `"password": "[REDACTED]"`) || !strings.Contains(out, `"user": "ada"`) {
+		t.Errorf("encoded request body not decoded and redacted:\n%s", out)
+	}
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDiffCells bounds the line-diff table. Larger changes are shown as a plain
+// removal followed by an addition.
+const maxDiffCells = 1 << 22
+
+// diffContext is the number of unchanged lines kept around each change.
+const diffContext = 2
+
+// rewriteRule is one entry of the -rewrite file, a JSON array of rules. Every rule whose
+// predicates match the request as forwarded upstream applies, in file order.
+type rewriteRule struct {
+	Name string `json:"name,omitempty"`
+	requestPredicates
+	Request  *messageEdit `json:"request,omitempty"`  // applied before forwarding
+	Response *messageEdit `json:"response,omitempty"` // applied before returning to the client
+
+	label string // log label, "rewrite <name>" or "rewrite #<n>"
+}
+
+// messageEdit describes the changes made to a request or response. Body edits work on
+// the decoded body; a changed body is sent without Content-Encoding. Header edits run
+// last, so they may still set any header.
+type messageEdit struct {
+	RemoveHeaders []string               `json:"remove_headers,omitempty"`
+	SetHeaders    map[string]string      `json:"set_headers,omitempty"`
+	AddHeaders    map[string]string      `json:"add_headers,omitempty"`
+	RemoveQuery   []string               `json:"remove_query,omitempty"` // requests only
+	SetQuery      map[string]string      `json:"set_query,omitempty"`    // requests only
+	Replace       []bodyReplacement      `json:"replace,omitempty"`
+	SetJSON       map[string]interface{} `json:"set_json,omitempty"` // JSON path -> new value
+
+	jsonEdits []jsonEdit
+}
+
+// bodyReplacement substitutes every match of Regex with With, which may refer to
+// submatches as $1 or ${name}.
+type bodyReplacement struct {
+	Regex string `json:"regex"`
+	With  string `json:"with"`
+
+	re *regexp.Regexp
+}
+
+// jsonEdit sets the values reached by a JSON path.
+type jsonEdit struct {
+	steps []jsonPathStep
+	value interface{}
+}
+
+// rewriter edits requests and responses matching its rules. A nil *rewriter matches nothing.
+type rewriter struct {
+	rules []*rewriteRule
+}
+
+// loadRewrites reads and validates the rules of a -rewrite file.
+func loadRewrites(path string) (*rewriter, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path comes from the -rewrite flag
+	if err != nil {
+		return nil, err
+	}
+	var rules []*rewriteRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i+1)
+		}
+		rule.label = "rewrite " + name
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("%s: rule %s: %w", path, name, err)
+		}
+	}
+	return &rewriter{rules: rules}, nil
+}
+
+func (rule *rewriteRule) compile() error {
+	if rule.Request == nil && rule.Response == nil {
+		return fmt.Errorf("neither request nor response edits")
+	}
+	if err := rule.requestPredicates.compile(); err != nil {
+		return err
+	}
+	if rule.Response != nil && (len(rule.Response.SetQuery) > 0 || len(rule.Response.RemoveQuery) > 0) {
+		return fmt.Errorf("query edits only apply to requests")
+	}
+	for _, edit := range []*messageEdit{rule.Request, rule.Response} {
+		if err := edit.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *messageEdit) compile() error {
+	if e == nil {
+		return nil
+	}
+	var err error
+	for i := range e.Replace {
+		if e.Replace[i].re, err = regexp.Compile(e.Replace[i].Regex); err != nil {
+			return err
+		}
+	}
+	paths := make([]string, 0, len(e.SetJSON))
+	for path := range e.SetJSON {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths) // apply in a stable order
+	for _, path := range paths {
+		steps, err := parseJSONPath(path)
+		if err != nil {
+			return err
+		}
+		e.jsonEdits = append(e.jsonEdits, jsonEdit{steps: steps, value: e.SetJSON[path]})
+	}
+	return nil
+}
+
+// Match returns every rule matching the request r with the decoded body.
+func (rw *rewriter) Match(r *http.Request, body []byte) []*rewriteRule {
+	if rw == nil {
+		return nil
+	}
+	var matched []*rewriteRule
+	req := &requestBody{raw: body}
+	for _, rule := range rw.rules {
+		if rule.Match(r, req) {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
+
+// editsBody reports whether e changes bodies.
+func (e *messageEdit) editsBody() bool {
+	return len(e.Replace) > 0 || len(e.jsonEdits) > 0
+}
+
+// editHeader applies the header edits to h.
+func (e *messageEdit) editHeader(h http.Header) {
+	for _, name := range e.RemoveHeaders {
+		h.Del(name)
+	}
+	for name, value := range e.SetHeaders {
+		h.Set(name, value)
+	}
+	for name, value := range e.AddHeaders {
+		h.Add(name, value)
+	}
+}
+
+// editQuery applies the query edits to u. Untouched parameters keep their order and
+// encoding; a set parameter replaces the first occurrence or is appended.
+func (e *messageEdit) editQuery(u *url.URL) {
+	if len(e.RemoveQuery) == 0 && len(e.SetQuery) == 0 {
+		return
+	}
+	var pairs []string
+	set := make(map[string]bool, len(e.SetQuery))
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		value, ok := e.SetQuery[name]
+		switch {
+		case slices.Contains(e.RemoveQuery, name):
+		case ok && !set[name]:
+			set[name] = true
+			pairs = append(pairs, key+"="+url.QueryEscape(value))
+		case !ok:
+			pairs = append(pairs, pair)
+		}
+	}
+	names := make([]string, 0, len(e.SetQuery))
+	for name := range e.SetQuery {
+		if !set[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pairs = append(pairs, url.QueryEscape(name)+"="+url.QueryEscape(e.SetQuery[name]))
+	}
+	u.RawQuery = strings.Join(pairs, "&")
+}
+
+// editBody applies the replacements and JSON overrides to a decoded body and reports
+// whether it changed.
+func (e *messageEdit) editBody(body []byte) ([]byte, bool) {
+	out := body
+	for _, r := range e.Replace {
+		out = r.re.ReplaceAll(out, []byte(r.With))
+	}
+	if len(e.jsonEdits) > 0 {
+		out = setJSON(out, e.jsonEdits)
+	}
+	return out, !bytes.Equal(out, body)
+}
+
+// setJSON applies JSON path overrides to a JSON body. A path of plain keys that does not
+// exist yet is created; bodies that are not JSON are returned unchanged.
+func setJSON(body []byte, edits []jsonEdit) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return body
+	}
+	changed := false
+	for _, edit := range edits {
+		var hit bool
+		if v, hit = replaceJSONPath(v, edit.steps, cloneJSON(edit.value)); !hit {
+			v, hit = insertJSONPath(v, edit.steps, cloneJSON(edit.value))
+		}
+		changed = changed || hit
+	}
+	if !changed {
+		return body
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return body
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n"))
+}
+
+// insertJSONPath creates the value at a path of plain object keys, adding missing
+// intermediate objects. Paths with wildcards, indexes or descendant segments are not created.
+func insertJSONPath(v interface{}, steps []jsonPathStep, value interface{}) (interface{}, bool) {
+	if len(steps) == 0 {
+		return value, true
+	}
+	step := steps[0]
+	if step.index != -1 || step.recursive || step.name == "*" {
+		return v, false
+	}
+	if v == nil {
+		v = map[string]interface{}{}
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return v, false
+	}
+	child, ok := insertJSONPath(obj[step.name], steps[1:], value)
+	if ok {
+		obj[step.name] = child
+	}
+	return obj, ok
+}
+
+// cloneJSON copies a decoded JSON value so every edited body gets its own objects.
+func cloneJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			out[k] = cloneJSON(child)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, child := range t {
+			out[i] = cloneJSON(child)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// rewriteRequest matches the -rewrite rules against ex.Request as forwarded and applies
+// their request edits. dump is the dumped head of the original request; the head of the
+// request that will actually be sent is returned and its body replaces ex.ReqBody. The
+// changes are kept in ex.RequestDiff.
+func (t DebugTransport) rewriteRequest(ex *exchange, dump []byte) ([]byte, error) {
+	if t.Rewrites == nil {
+		return dump, nil
+	}
+	r, body := ex.Request, ex.ReqBody
+	// As for responses, only bodies up to maxLogBodySize are decoded for body predicates
+	// and edits; larger ones are forwarded unchanged.
+	var decoded []byte
+	tooLarge := len(body) > maxLogBodySize
+	if !tooLarge {
+		var err error
+		if decoded, err = decodeBody(r.Header.Get("Content-Encoding"), body); err != nil {
+			decoded = nil // leave bodies we cannot read alone
+		}
+	}
+	ex.rewrites = t.Rewrites.Match(r, decoded)
+	original := decoded
+	if original == nil {
+		original = body
+	}
+	out := r.Clone(r.Context())
+	edited := false
+	for _, rule := range ex.rewrites {
+		ex.Labels = append(ex.Labels, rule.label)
+		e := rule.Request
+		if e == nil {
+			continue
+		}
+		if e.editsBody() && decoded != nil {
+			var changed bool
+			if decoded, changed = e.editBody(decoded); changed {
+				body = decoded
+				out.Header.Del("Content-Encoding")
+				out.ContentLength = int64(len(body))
+				out.TransferEncoding = nil
+			}
+		}
+		e.editHeader(out.Header)
+		if host := out.Header.Get("Host"); host != "" {
+			out.Host = host
+			out.Header.Del("Host")
+		}
+		e.editQuery(out.URL)
+		edited = true
+		if e.editsBody() && tooLarge {
+			log.Printf("rewrite %d: request body larger than %s, not rewritten", ex.ID, formatBytes(maxLogBodySize))
+		}
+	}
+	if !edited {
+		return dump, nil
+	}
+	out.Body = http.NoBody
+	if len(body) > 0 {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		out.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	}
+	rewritten, err := httputil.DumpRequestOut(out, false)
+	if err != nil {
+		return nil, err
+	}
+	ex.Request, ex.ReqBody = out, body
+	before, _, _ := bytes.Cut(dump, []byte("\r\n\r\n"))
+	after, _, _ := bytes.Cut(rewritten, []byte("\r\n\r\n"))
+	ex.RequestDiff = diffLines(
+		messageLines(t.Redact, before, original, r.Header.Get("Content-Type")),
+		messageLines(t.Redact, after, decodedOrRaw(out.Header.Get("Content-Encoding"), body), out.Header.Get("Content-Type")),
+	)
+	return rewritten, nil
+}
+
+// rewriteResponse applies the response edits of the rules matched by rewriteRequest to
+// ex.Response. Bodies are buffered for body edits, except for streams, upgrades and bodies
+// larger than maxLogBodySize, which pass through unchanged. The changes are kept in
+// ex.ResponseDiff.
+func (t DebugTransport) rewriteResponse(ex *exchange) {
+	resp := ex.Response
+	var edits []*messageEdit
+	for _, rule := range ex.rewrites {
+		if rule.Response != nil {
+			edits = append(edits, rule.Response)
+		}
+	}
+	if len(edits) == 0 {
+		return
+	}
+	before, err := dumpResponseHead(resp)
+	if err != nil {
+		log.Printf("rewrite %d: %v", ex.ID, err)
+		return
+	}
+	contentType := resp.Header.Get("Content-Type")
+	var origBody, body []byte
+	if needsBody(edits) && resp.StatusCode != http.StatusSwitchingProtocols && !isEventStream(resp) {
+		var ok bool
+		if origBody, body, ok = editResponseBody(resp, edits); !ok {
+			log.Printf("rewrite %d: response body larger than %s, not rewritten", ex.ID, formatBytes(maxLogBodySize))
+		}
+	}
+	for _, e := range edits {
+		e.editHeader(resp.Header)
+	}
+	after, err := dumpResponseHead(resp)
+	if err != nil {
+		log.Printf("rewrite %d: %v", ex.ID, err)
+		return
+	}
+	ex.ResponseDiff = diffLines(
+		messageLines(t.Redact, before, origBody, contentType),
+		messageLines(t.Redact, after, body, resp.Header.Get("Content-Type")),
+	)
+}
+
+// editResponseBody applies the body edits to resp and returns the decoded body before and
+// after them. Only bodies up to maxLogBodySize are buffered; larger ones are streamed to
+// the client unchanged and reported with ok false.
+func editResponseBody(resp *http.Response, edits []*messageEdit) (before, after []byte, ok bool) {
+	if resp.ContentLength > maxLogBodySize {
+		return nil, nil, false
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxLogBodySize+1))
+	if err != nil {
+		// Leave the body as received; the client sees the same error the proxy did.
+		_ = resp.Body.Close()
+		resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(raw), errorReader{err}))
+		return nil, nil, true
+	}
+	if len(raw) > maxLogBodySize {
+		resp.Body = prefixedBody{Reader: io.MultiReader(bytes.NewReader(raw), resp.Body), Closer: resp.Body}
+		return nil, nil, false
+	}
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	decoded, err := decodeBody(resp.Header.Get("Content-Encoding"), raw)
+	if err != nil {
+		return nil, nil, true
+	}
+	body, changed := decoded, false
+	for _, e := range edits {
+		var c bool
+		body, c = e.editBody(body)
+		changed = changed || c
+	}
+	if changed {
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		resp.ContentLength = int64(len(body))
+		resp.TransferEncoding = nil
+		resp.Uncompressed = false
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return decoded, body, true
+}
+
+// prefixedBody reads the part of a body already consumed before the rest, and closes the
+// original body.
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+func needsBody(edits []*messageEdit) bool {
+	for _, e := range edits {
+		if e.editsBody() {
+			return true
+		}
+	}
+	return false
+}
+
+// errorReader fails every read with err.
+type errorReader struct{ err error }
+
+func (r errorReader) Read([]byte) (int, error) { return 0, r.err }
+
+func decodedOrRaw(encoding string, body []byte) []byte {
+	decoded, err := decodeBody(encoding, body)
+	if err != nil {
+		return body
+	}
+	return decoded
+}
+
+// messageLines renders a message head and decoded body as redacted plain-text lines for
+// diffing. JSON and XML bodies are pretty-printed so changes show up line by line.
+func messageLines(rd *redactor, head, body []byte, contentType string) []string {
+	lines := strings.Split(string(rd.HeaderBlock(head)), "\r\n")
+	switch {
+	case len(body) == 0:
+	case len(body) > maxLogBodySize:
+		lines = append(lines, "", fmt.Sprintf("[body too large to display: %d bytes]", len(body)))
+	default:
+		text := renderBody(plainPainter, rd.Body(contentType, body), contentType, false)
+		lines = append(lines, "")
+		lines = append(lines, strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")...)
+	}
+	return lines
+}
+
+// diffLines returns a line diff of a and b: changed lines are prefixed with "- " or "+ ",
+// and up to diffContext unchanged lines around each change with "  ". Skipped unchanged
+// lines are replaced by "...". Identical inputs give nil.
+func diffLines(a, b []string) []string {
+	if slices.Equal(a, b) {
+		return nil
+	}
+	return trimContext(diffOps(a, b))
+}
+
+// diffOps returns every line of a and b prefixed with "  " when unchanged, "- " when only
+// in a and "+ " when only in b.
+func diffOps(a, b []string) []string {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var ops []string
+	for _, line := range a[:prefix] {
+		ops = append(ops, "  "+line)
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, "  "+line)
+	}
+	return ops
+}
+
+// diffMiddle diffs lines that differ at both ends with a longest common subsequence.
+func diffMiddle(a, b []string) []string {
+	var ops []string
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, "- "+line)
+		}
+		for _, line := range b {
+			ops = append(ops, "+ "+line)
+		}
+		return ops
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, "  "+a[i])
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, "- "+a[i])
+			i++
+		default:
+			ops = append(ops, "+ "+b[j])
+			j++
+		}
+	}
+	return ops
+}
+
+// trimContext keeps diffContext unchanged lines around each change.
+func trimContext(ops []string) []string {
+	keep := make([]bool, len(ops))
+	for i, op := range ops {
+		if strings.HasPrefix(op, "  ") {
+			continue
+		}
+		for j := max(i-diffContext, 0); j <= min(i+diffContext, len(ops)-1); j++ {
+			keep[j] = true
+		}
+	}
+	var out []string
+	for i, op := range ops {
+		switch {
+		case keep[i]:
+			out = append(out, op)
+		case len(out) == 0 || out[len(out)-1] != "...":
+			out = append(out, "...")
+		}
+	}
+	return out
+}
+
+// diffBlock formats the changes of a rewritten request or response for the text log.
+func diffBlock(id int64, kind string, diff []string, color string) string {
+	line := wrapColor(fmt.Sprintf("--- REWRITE %d %s ---", id, kind), color)
+	return fmt.Sprintf("%s %s\n\n%s\n", coloredTime(time.Now(), color), line, formatDiff(diff))
+}
+
+// formatDiff colors removed lines red and added lines green, one line per entry.
+func formatDiff(diff []string) string {
+	var b strings.Builder
+	for _, op := range diff {
+		switch {
+		case strings.HasPrefix(op, "- "):
+			op = wrapColor(op, colorStatus5xx)
+		case strings.HasPrefix(op, "+ "):
+			op = wrapColor(op, colorStatus2xx)
+		}
+		b.WriteString(op + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRewritesErrors(t *testing.T) {
+	tests := []struct{ name, rules, want string }{
+		{name: "syntax", rules: `[{`, want: "unexpected end"},
+		{name: "no edits", rules: `[{"name": "empty", "path": "/api"}]`, want: "rule empty: neither request nor response edits"},
+		{name: "regex", rules: `[{"request": {"replace": [{"regex": "(", "with": ""}]}}]`, want: "rule #1"},
+		{name: "json path", rules: `[{"response": {"set_json": {"user": 1}}}]`, want: "must start with $"},
+		{name: "response query", rules: `[{"response": {"set_query": {"a": "1"}}}]`, want: "only apply to requests"},
+		{name: "predicate", rules: `[{"body": {"regex": "["}, "request": {}}]`, want: "rule #1"},
+	}
+	for _, tt := range tests {
+		_, err := loadRewrites(writeMockFile(t, tt.rules))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestMessageEditQuery(t *testing.T) {
+	edit := &messageEdit{RemoveQuery: []string{"debug"}, SetQuery: map[string]string{"page": "2", "lang": "en us"}}
+	tests := []struct{ in, want string }{
+		{in: "q=a%20b&page=1&debug=1&page=3", want: "q=a%20b&page=2&lang=en+us"},
+		{in: "", want: "lang=en+us&page=2"},
+		{in: "debug", want: "lang=en+us&page=2"},
+	}
+	for _, tt := range tests {
+		u := &url.URL{Path: "/", RawQuery: tt.in}
+		edit.editQuery(u)
+		if u.RawQuery != tt.want {
+			t.Errorf("editQuery(%q) = %q, want %q", tt.in, u.RawQuery, tt.want)
+		}
+	}
+}
+
+func TestMessageEditBody(t *testing.T) {
+	rw, err := loadRewrites(writeMockFile(t, `[{"request": {
+		"replace": [{"regex": "v(\\d)", "with": "version-$1"}],
+		"set_json": {"$.items[*].price": 0, "$.meta.source": "proxy", "$.user": {"role": "admin"}, "$.user.name": "ada"}
+	}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := rw.rules[0].Request
+	tests := []struct{ in, want string }{
+		{in: `{"api": "v1", "items": [{"price": 12.5}, {"price": 3}], "html": "<b>"}`,
+			want: `{"api":"version-1","html":"<b>","items":[{"price":0},{"price":0}],"meta":{"source":"proxy"},"user":{"name":"ada","role":"admin"}}`},
+		{in: `{"count": 12345678901234567890, "meta": "flat"}`,
+			want: `{"count":12345678901234567890,"meta":"flat","user":{"name":"ada","role":"admin"}}`},
+		{in: `plain v2 text`, want: `plain version-2 text`},
+	}
+	for _, tt := range tests {
+		got, changed := edit.editBody([]byte(tt.in))
+		if string(got) != tt.want || !changed {
+			t.Errorf("editBody(%s) = %s, %v\nwant %s", tt.in, got, changed, tt.want)
+		}
+	}
+	// Edited bodies must not share objects with the rule or with each other.
+	first, _ := edit.editBody([]byte(`{}`))
+	second, _ := edit.editBody([]byte(`{}`))
+	if !bytes.Equal(first, second) {
+		t.Errorf("repeated edits differ: %s vs %s", first, second)
+	}
+	if _, changed := (&messageEdit{}).editBody([]byte("x")); changed {
+		t.Error("empty edit changed the body")
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	a := []string{"GET / HTTP/1.1", "Host: a", "X-Keep: 1", "X-Old: 1", "", "1", "2", "3", "4", "5", "6", "7"}
+	b := []string{"GET / HTTP/1.1", "Host: a", "X-Keep: 1", "X-New: 1", "", "1", "2", "3", "4", "5", "6", "seven"}
+	want := []string{
+		"...",
+		"  Host: a", "  X-Keep: 1", "- X-Old: 1", "+ X-New: 1", "  ", "  1",
+		"...",
+		"  5", "  6", "- 7", "+ seven",
+	}
+	got := diffLines(a, b)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("diffLines:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if diffLines(a, a) != nil {
+		t.Error("identical inputs produced a diff")
+	}
+	if got := diffLines([]string{"x"}, []string{"x", "y"}); strings.Join(got, "|") != "  x|+ y" {
+		t.Errorf("append diff = %q", got)
+	}
+}
+
+func TestRewriteThroughProxy(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	type received struct {
+		host, query, auth, body string
+		agents                  []string
+	}
+	got := make(chan received, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{host: r.Host, query: r.URL.RawQuery, auth: r.Header.Get("Authorization"), body: string(body), agents: r.Header.Values("X-Agent")}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Server", "internal/1.0")
+		gz := gzip.NewWriter(w)
+		_, _ = io.WriteString(gz, `{"user": {"id": 7, "email": "ada@internal.test"}, "token": "secret"}`)
+		_ = gz.Close()
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	rewrites, err := loadRewrites(writeMockFile(t, `[
+		{"name": "staging", "method": "POST", "path": "/users",
+			"request": {
+				"set_headers": {"Host": "staging.test", "Authorization": "Bearer test-token"},
+				"add_headers": {"X-Agent": "proxy"},
+				"set_query": {"env": "staging"},
+				"remove_query": ["debug"],
+				"set_json": {"$.role": "tester"}
+			},
+			"response": {
+				"remove_headers": ["Server"],
+				"replace": [{"regex": "@internal\\.test", "with": "@example.com"}]
+			}},
+		{"path": "/none", "response": {"set_headers": {"X-Unused": "1"}}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	redact, err := newRedactor(nil, []string{"$.token"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := DebugTransport{Rewrites: rewrites, Redact: redact}
+	proxy := httptest.NewServer(&httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite:   func(pr *httputil.ProxyRequest) { pr.SetURL(target) },
+	})
+	defer proxy.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/users?debug=1&page=2", strings.NewReader(`{"name": "ada", "role": "admin"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer prod-token")
+	req.Header.Set("X-Agent", "client")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	r := <-got
+	if r.host != "staging.test" || r.query != "page=2&env=staging" || r.auth != "Bearer test-token" ||
+		r.body != `{"name":"ada","role":"tester"}` || strings.Join(r.agents, ",") != "client,proxy" {
+		t.Errorf("upstream received %+v", r)
+	}
+	if want := `{"user": {"id": 7, "email": "ada@example.com"}, "token": "secret"}`; string(body) != want {
+		t.Errorf("client received %s, want %s", body, want)
+	}
+	if resp.Header.Get("Server") != "" || resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("response headers not rewritten: %v", resp.Header)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for strings.Count(buf.String(), "--- REWRITE") < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"[rewrite staging] ---",
+		"request ---",
+		"- POST /users?debug=1&page=2 HTTP/1.1",
+		"+ POST /users?page=2&env=staging HTTP/1.1",
+		"+ Host: staging.test",
+		"-   \"role\": \"admin\"",
+		"+   \"role\": \"tester\"",
+		"response ---",
+		"- Content-Encoding: gzip",
+		"- Server: internal/1.0",
+		"+     \"email\": \"ada@example.com\",",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret") || strings.Contains(out, "X-Unused") {
+		t.Errorf("log shows a redacted value or an unmatched rule:\n%s", out)
+	}
+}
+
+func TestEditResponseBodyTooLarge(t *testing.T) {
+	edits := []*messageEdit{{Replace: []bodyReplacement{{Regex: "a", With: "b"}}}}
+	if err := edits[0].compile(); err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("a", maxLogBodySize+10)
+	for _, length := range []int64{int64(len(large)), -1} {
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{},
+			ContentLength: length,
+			Body:          io.NopCloser(strings.NewReader(large)),
+		}
+		before, after, ok := editResponseBody(resp, edits)
+		body, err := io.ReadAll(resp.Body)
+		if ok || before != nil || after != nil || err != nil || string(body) != large || resp.ContentLength != length {
+			t.Errorf("length %d: ok %v, got %d bytes, err %v", length, ok, len(body), err)
+		}
+	}
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, ContentLength: -1, Body: io.NopCloser(strings.NewReader("aa"))}
+	if _, after, ok := editResponseBody(resp, edits); !ok || string(after) != "bb" {
+		t.Errorf("small body: ok %v, after %q", ok, after)
+	}
+}
+
+func TestRewriterMatchJSON(t *testing.T) {
+	rw, err := loadRewrites(writeMockFile(t, `[
+		{"name": "admin", "body": {"json": {"$.role": "admin"}}, "request": {"add_headers": {"X-A": "1"}}},
+		{"name": "any", "request": {"add_headers": {"X-A": "2"}}},
+		{"name": "ada", "method": "POST", "body": {"json": {"$.name": "ada"}}, "request": {"add_headers": {"X-A": "3"}}},
+		{"name": "bob", "body": {"json": {"$.name": "bob"}}, "request": {"add_headers": {"X-A": "4"}}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/users", nil)
+	var names []string
+	for _, rule := range rw.Match(r, []byte(`{"name": "ada", "role": "admin"}`)) {
+		names = append(names, rule.Name)
+	}
+	if got := strings.Join(names, ","); got != "admin,any,ada" {
+		t.Errorf("matched %s, want admin,any,ada", got)
+	}
+	if got := rw.Match(r, []byte("not json")); len(got) != 1 || got[0].Name != "any" {
+		t.Errorf("non-JSON body matched %d rules", len(got))
+	}
+}
+
+func TestRewriteRequestTooLarge(t *testing.T) {
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	type received struct {
+		header string
+		body   []byte
+	}
+	got := make(chan received, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{header: r.Header.Get("X-Rewritten"), body: body}
+	}))
+	defer upstream.Close()
+
+	rewrites, err := loadRewrites(writeMockFile(t, `[{"request": {"set_headers": {"X-Rewritten": "1"}, "replace": [{"regex": "a", "with": "b"}]}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("a", maxLogBodySize+10)
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL, strings.NewReader(large))
+	resp, err := DebugTransport{Rewrites: rewrites}.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if r := <-got; r.header != "1" || string(r.body) != large {
+		t.Errorf("upstream received header %q and %d bytes", r.header, len(r.body))
+	}
+	if !strings.Contains(buf.String(), "request body larger than 1.0 MB, not rewritten") {
+		t.Errorf("log does not note the skipped body:\n%.500s", buf.String())
+	}
+}
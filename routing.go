@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// defaultRouteName labels exchanges that matched no -route and went to -target.
+const defaultRouteName = "default"
+
+// routeList is the repeatable -route flag. Unlike stringList it keeps commas,
+// which route conditions use for alternatives (e.g. "method=GET,HEAD").
+type routeList []string
+
+func routeListFlag(name, usage string) *routeList {
+	s := &routeList{}
+	flag.Var(s, name, usage)
+	return s
+}
+
+func (s *routeList) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *routeList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// route sends matching requests to its own upstream. A spec has the form
+//
+//	<condition>[&<condition>...]=<target URL>[;strip][;name=<name>]
+//
+// A condition starting with "/" is a path prefix (or glob, see matchPathPattern);
+// anything else uses the -filter syntax, e.g. "host=api.local" or "header.X-Tenant=acme".
+// "strip" removes the path prefix before forwarding. The name defaults to the target host.
+type route struct {
+	requestMatch
+	name   string
+	target *url.URL
+	strip  bool
+}
+
+// requestMatch is the condition part of a -route or -fault spec: an optional path prefix
+// (or glob) plus -filter conditions on the request, all of which must hold.
+type requestMatch struct {
+	prefix string
+	conds  []filterCond
+}
+
+// parseRequestMatch parses "&"-separated conditions such as "/api&method=POST".
+func parseRequestMatch(match string) (requestMatch, error) {
+	var m requestMatch
+	for _, cond := range strings.Split(match, "&") {
+		if strings.HasPrefix(cond, "/") {
+			if m.prefix != "" {
+				return m, fmt.Errorf("more than one path prefix")
+			}
+			m.prefix = cond
+			continue
+		}
+		c, err := parseFilterCond(cond)
+		if err != nil {
+			return m, err
+		}
+		if c.field == "status" {
+			return m, fmt.Errorf("status is not known before the request is sent")
+		}
+		m.conds = append(m.conds, c)
+	}
+	return m, nil
+}
+
+// Match reports whether r satisfies every condition.
+func (m requestMatch) Match(r *http.Request) bool {
+	if m.prefix != "" && !matchPathPattern(m.prefix, r.URL.Path) {
+		return false
+	}
+	for _, c := range m.conds {
+		if !c.match(requestField(r, c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRoute parses a -route spec such as "/api=http://api:8080;strip".
+func parseRoute(spec string) (*route, error) {
+	scheme := strings.Index(spec, "://")
+	if scheme == -1 {
+		return nil, fmt.Errorf("invalid route %q: expected <condition>=<target URL>", spec)
+	}
+	sep := strings.LastIndex(spec[:scheme], "=")
+	if sep <= 0 {
+		return nil, fmt.Errorf("invalid route %q: expected <condition>=<target URL>", spec)
+	}
+	match, rest := spec[:sep], spec[sep+1:]
+	options := strings.Split(rest, ";")
+	target, err := parseTarget(options[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid route %q: %w", spec, err)
+	}
+	rt := &route{name: target.Host, target: target}
+	for _, opt := range options[1:] {
+		name, isName := strings.CutPrefix(opt, "name=")
+		switch {
+		case opt == "strip":
+			rt.strip = true
+		case isName && name != "":
+			rt.name = name
+		default:
+			return nil, fmt.Errorf("invalid route %q: unknown option %q", spec, opt)
+		}
+	}
+	if rt.requestMatch, err = parseRequestMatch(match); err != nil {
+		return nil, fmt.Errorf("invalid route %q: %w", spec, err)
+	}
+	if rt.strip && (rt.prefix == "" || strings.ContainsAny(rt.prefix, "*?[")) {
+		return nil, fmt.Errorf("invalid route %q: strip needs a plain path prefix", spec)
+	}
+	return rt, nil
+}
+
+// router picks the upstream for each request: the first matching route, or the
+// -target fallback.
+type router struct {
+	routes   []*route
+	fallback *route
+}
+
+// newRouter parses the -route specs. fallback receives unmatched requests.
+func newRouter(specs []string, fallback *url.URL) (*router, error) {
+	rtr := &router{fallback: &route{name: defaultRouteName, target: fallback}}
+	for _, spec := range specs {
+		rt, err := parseRoute(spec)
+		if err != nil {
+			return nil, err
+		}
+		rtr.routes = append(rtr.routes, rt)
+	}
+	return rtr, nil
+}
+
+// Select returns the route for the inbound request r.
+func (rtr *router) Select(r *http.Request) *route {
+	for _, rt := range rtr.routes {
+		if rt.Match(r) {
+			return rt
+		}
+	}
+	return rtr.fallback
+}
+
+// Rewrite is the ReverseProxy Rewrite hook. The client's Host and, when routes are
+// configured, the chosen route's name travel to DebugTransport in the request context.
+func (rtr *router) Rewrite(pr *httputil.ProxyRequest) {
+	rt := rtr.Select(pr.In)
+	if rt.strip {
+		stripPathPrefix(pr.Out.URL, rt.prefix)
+	}
+	pr.SetURL(rt.target)
+	pr.Out.Host = rt.target.Host
+	keepClientHost(pr)
+	if len(rtr.routes) > 0 {
+		pr.Out = pr.Out.WithContext(context.WithValue(pr.Out.Context(), routeKey{}, rt.name))
+	}
+}
+
+// stripPathPrefix removes prefix from u's path, keeping at least "/".
+func stripPathPrefix(u *url.URL, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	trim := func(p string) string {
+		p = strings.TrimPrefix(p, prefix)
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		return p
+	}
+	u.Path = trim(u.Path)
+	if u.RawPath != "" {
+		u.RawPath = trim(u.RawPath)
+	}
+}
+
+type routeKey struct{}
+
+// routeName returns the route chosen for an outbound request, or "" without -route.
+func routeName(ctx context.Context) string {
+	name, _ := ctx.Value(routeKey{}).(string)
+	return name
+}
+
+// parseTarget parses an upstream URL, requiring a scheme and host.
+func parseTarget(raw string) (*url.URL, error) {
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL %q: %w", raw, err)
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("invalid target URL %q: scheme and host are required", raw)
+	}
+	return target, nil
+}
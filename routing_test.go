@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		spec   string
+		name   string
+		target string
+		prefix string
+		conds  int
+		strip  bool
+	}{
+		{spec: "/api=http://api:8080", name: "api:8080", target: "http://api:8080", prefix: "/api"},
+		{spec: "/auth=http://auth:9000/v1;strip;name=auth", name: "auth", target: "http://auth:9000/v1", prefix: "/auth", strip: true},
+		{spec: "host=admin.local=http://admin", name: "admin", target: "http://admin", conds: 1},
+		{spec: "header.X-Tenant=a=b&/t=https://tenant?x=1", name: "tenant", target: "https://tenant?x=1", prefix: "/t", conds: 1},
+		{spec: "method=GET,HEAD&host=*.cdn=http://cdn", name: "cdn", target: "http://cdn", conds: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			rt, err := parseRoute(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rt.name != tt.name || rt.target.String() != tt.target || rt.prefix != tt.prefix ||
+				len(rt.conds) != tt.conds || rt.strip != tt.strip {
+				t.Errorf("parseRoute(%q) = %+v", tt.spec, rt)
+			}
+		})
+	}
+
+	for _, spec := range []string{
+		"/api",
+		"=http://api",
+		"/api=api:8080",
+		"/api=http://api;bogus",
+		"/a&/b=http://api",
+		"status=500=http://api",
+		"color=red=http://api",
+		"host=x=http://api;strip",
+		"/static/*=http://api;strip",
+	} {
+		if _, err := parseRoute(spec); err == nil {
+			t.Errorf("parseRoute(%q) expected error", spec)
+		}
+	}
+}
+
+func TestRouterSelect(t *testing.T) {
+	fallback, _ := url.Parse("http://fallback")
+	rtr, err := newRouter([]string{
+		"/api=http://api;name=api",
+		"host=auth.local=http://auth;name=auth",
+		"header.X-Tenant=acme=http://acme;name=acme",
+	}, fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target string
+		header string
+		want   string
+	}{
+		{target: "http://proxy/api/users", want: "api"},
+		{target: "http://proxy/apiary", want: defaultRouteName},
+		{target: "http://auth.local:1338/login", want: "auth"},
+		{target: "http://auth.local/api", want: "api"},
+		{target: "http://proxy/", header: "acme", want: "acme"},
+		{target: "http://proxy/", header: "other", want: defaultRouteName},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.header != "" {
+			req.Header.Set("X-Tenant", tt.header)
+		}
+		if got := rtr.Select(req).name; got != tt.want {
+			t.Errorf("Select(%s, X-Tenant=%q) = %q, want %q", tt.target, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestStripPathPrefix(t *testing.T) {
+	tests := []struct {
+		path, prefix, want string
+	}{
+		{"/api/users", "/api", "/users"},
+		{"/api/users", "/api/", "/users"},
+		{"/api", "/api", "/"},
+		{"/api/a%2Fb", "/api", "/a%2Fb"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse("http://h" + tt.path)
+		stripPathPrefix(u, tt.prefix)
+		if got := u.EscapedPath(); got != tt.want {
+			t.Errorf("stripPathPrefix(%q, %q) = %q, want %q", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestProxyRoutesAndLabelsExchanges(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name+" "+r.URL.Path)
+		}))
+	}
+	api, web := backend("api"), backend("web")
+	defer api.Close()
+	defer web.Close()
+
+	webURL, _ := url.Parse(web.URL)
+	rtr, err := newRouter([]string{"/api=" + api.URL + ";strip;name=api"}, webURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&httputil.ReverseProxy{Transport: DebugTransport{}, Rewrite: rtr.Rewrite})
+	defer proxy.Close()
+
+	for path, want := range map[string]string{"/api/users": "api /users", "/index.html": "web /index.html"} {
+		resp, err := http.Get(proxy.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("GET %s = %q, want %q", path, body, want)
+		}
+	}
+
+	out := buf.String()
+	for _, want := range []string{"[route api] ---", "[route default] ---"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+}
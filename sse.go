@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sseEvent is a single dispatched Server-Sent Event.
+type sseEvent struct {
+	Seq   int    // 1-based position within the stream
+	ID    string // last "id:" field seen in the event
+	Event string // "event:" field; empty means the default "message" type
+	Data  string // "data:" lines joined with "\n"
+	Retry string // "retry:" field, if any
+}
+
+// isEventStream reports whether the response is an uncompressed text/event-stream that
+// can be parsed event by event as it passes through the proxy.
+func isEventStream(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/event-stream" {
+		return false
+	}
+	enc := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	return enc == "" || enc == "identity"
+}
+
+// sseParser incrementally parses an event stream as described in the HTML
+// Living Standard. Bytes can arrive in arbitrary chunks; onEvent is called for
+// every complete event.
+type sseParser struct {
+	line    []byte
+	lastCR  bool
+	seq     int
+	cur     sseEvent
+	data    []string
+	hasData bool
+	onEvent func(sseEvent)
+}
+
+// Write feeds raw stream bytes to the parser. It never fails.
+func (p *sseParser) Write(b []byte) (int, error) {
+	for _, c := range b {
+		switch {
+		case c == '\n' && p.lastCR:
+			// second half of a CRLF pair, the line was already processed
+			p.lastCR = false
+		case c == '\n' || c == '\r':
+			p.lastCR = c == '\r'
+			p.processLine(string(p.line))
+			p.line = p.line[:0]
+		default:
+			p.lastCR = false
+			p.line = append(p.line, c)
+		}
+	}
+	return len(b), nil
+}
+
+func (p *sseParser) processLine(line string) {
+	if line == "" {
+		p.dispatch()
+		return
+	}
+	if strings.HasPrefix(line, ":") {
+		return // comment / keep-alive
+	}
+	field, value, _ := strings.Cut(line, ":")
+	value = strings.TrimPrefix(value, " ")
+	switch field {
+	case "data":
+		p.data = append(p.data, value)
+		p.hasData = true
+	case "event":
+		p.cur.Event = value
+	case "id":
+		p.cur.ID = value
+	case "retry":
+		p.cur.Retry = value
+	}
+}
+
+func (p *sseParser) dispatch() {
+	if p.hasData || p.cur.Event != "" || p.cur.ID != "" || p.cur.Retry != "" {
+		p.seq++
+		ev := p.cur
+		ev.Seq = p.seq
+		ev.Data = strings.Join(p.data, "\n")
+		p.onEvent(ev)
+	}
+	p.cur = sseEvent{}
+	p.data = p.data[:0]
+	p.hasData = false
+}
+
+// sseBody feeds every byte read from the wrapped body to an sseParser, so events
+// are reported as soon as the client has received them.
+type sseBody struct {
+	rc     io.ReadCloser
+	parser *sseParser
+}
+
+func newSSEBody(rc io.ReadCloser, onEvent func(sseEvent)) *sseBody {
+	return &sseBody{rc: rc, parser: &sseParser{onEvent: onEvent}}
+}
+
+func (b *sseBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if n > 0 {
+		_, _ = b.parser.Write(p[:n])
+	}
+	if errors.Is(err, io.EOF) {
+		// A trailing event without a terminating blank line is discarded per spec.
+		b.parser.line = b.parser.line[:0]
+	}
+	return n, err
+}
+
+func (b *sseBody) Close() error {
+	return b.rc.Close()
+}
+
+// logSSEEvent prints an event as a numbered sub-entry of its parent exchange.
+func logSSEEvent(parent int64, ev sseEvent) {
+	if *logFormat == formatJSONL {
+		writeJSONLEvent(parent, ev)
+		return
+	}
+	var b strings.Builder
+	if ev.ID != "" {
+		b.WriteString(wrapColor("id", colorHeader) + ": " + wrapColor(ev.ID, colorString) + "\n")
+	}
+	if ev.Event != "" {
+		b.WriteString(wrapColor("event", colorHeader) + ": " + wrapColor(ev.Event, colorString) + "\n")
+	}
+	if ev.Retry != "" {
+		b.WriteString(wrapColor("retry", colorHeader) + ": " + wrapColor(ev.Retry, colorNumber) + "\n")
+	}
+	b.WriteString(wrapColor("data", colorHeader) + ":")
+	if json.Valid([]byte(ev.Data)) && strings.TrimSpace(ev.Data) != "" {
+		b.WriteString("\n" + highlightJSON([]byte(ev.Data)))
+	} else if ev.Data != "" {
+		b.WriteString(" " + ev.Data)
+	}
+	line := wrapColor(fmt.Sprintf("--- EVENT %d.%d ---", parent, ev.Seq), colorEventMarker)
+	logEntry(parent, fmt.Sprintf("%s %s\n%s\n\n", coloredTime(time.Now(), colorEventMarker), line, b.String()))
+}
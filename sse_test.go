@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSSEParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []sseEvent
+	}{
+		{
+			name:   "single event",
+			chunks: []string{"data: hello\n\n"},
+			want:   []sseEvent{{Seq: 1, Data: "hello"}},
+		},
+		{
+			name:   "all fields and multi-line data",
+			chunks: []string{"id: 7\nevent: token\nretry: 1000\ndata: a\ndata: b\n\n"},
+			want:   []sseEvent{{Seq: 1, ID: "7", Event: "token", Retry: "1000", Data: "a\nb"}},
+		},
+		{
+			name:   "split across chunks with CRLF",
+			chunks: []string{"data: {\"x\"", ":1}\r", "\n\r\ndata: two\r\n\r\n"},
+			want:   []sseEvent{{Seq: 1, Data: `{"x":1}`}, {Seq: 2, Data: "two"}},
+		},
+		{
+			name:   "comments are ignored",
+			chunks: []string{": keep-alive\n\n", "data: x\n\n"},
+			want:   []sseEvent{{Seq: 1, Data: "x"}},
+		},
+		{
+			name:   "unterminated event is not dispatched",
+			chunks: []string{"data: partial"},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []sseEvent
+			p := &sseParser{onEvent: func(ev sseEvent) { got = append(got, ev) }}
+			for _, c := range tt.chunks {
+				_, _ = p.Write([]byte(c))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("event %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIsEventStream(t *testing.T) {
+	tests := []struct {
+		contentType string
+		encoding    string
+		want        bool
+	}{
+		{"text/event-stream", "", true},
+		{"text/event-stream; charset=utf-8", "identity", true},
+		{"text/event-stream", "gzip", false},
+		{"application/json", "", false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Content-Type", tt.contentType)
+		if tt.encoding != "" {
+			resp.Header.Set("Content-Encoding", tt.encoding)
+		}
+		if got := isEventStream(resp); got != tt.want {
+			t.Errorf("isEventStream(%q, %q) = %v, want %v", tt.contentType, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestRoundTripLogsSSEEvents(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "event: token\ndata: {\"text\":\"hi\"}\n\n")
+		_ = http.NewResponseController(w).Flush()
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	req, err := http.NewRequest(http.MethodGet, upstream.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := DebugTransport{}.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	want := "event: token\ndata: {\"text\":\"hi\"}\n\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(resp.Body, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("client received %q, want %q", got, want)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(buf.String(), "--- EVENT ") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "[event stream]") {
+		t.Errorf("stream headers not logged before completion: %q", out)
+	}
+	if !strings.Contains(out, ".1 ---") || !strings.Contains(out, "event: token") || !strings.Contains(out, `"text": "hi"`) {
+		t.Errorf("event not logged with highlighted JSON data: %q", out)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for use as a log output shared with other goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestEventStreamOutlastsServerTimeouts(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// The gaps between events exceed the timeout, so only clearing the deadlines helps.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range 3 {
+			_, _ = fmt.Fprintf(w, "data: token %d\n\n", i)
+			w.(http.Flusher).Flush() //nolint:forcetypeassert // httptest servers support flushing
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	proxy := newTimeoutProxy(DebugTransport{}, target, 150*time.Millisecond, true)
+	defer proxy.Close()
+	resp, err := http.Get(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil || strings.Count(string(body), "data: token") != 3 {
+		t.Errorf("event stream cut off: %q, err %v", body, err)
+	}
+}
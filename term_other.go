@@ -0,0 +1,21 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// terminalState is the terminal mode saved by makeRaw.
+type terminalState struct{}
+
+var errTerminalUnsupported = errors.New("interactive terminal mode is not supported on this platform")
+
+func makeRaw(uintptr) (*terminalState, error) { return nil, errTerminalUnsupported }
+
+func restoreTerminal(uintptr, *terminalState) error { return nil }
+
+func terminalSize(uintptr) (width, height int, err error) { return 0, 0, errTerminalUnsupported }
+
+func notifyResize(chan<- os.Signal) {}
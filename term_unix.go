@@ -0,0 +1,60 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// terminalState is the terminal mode saved by makeRaw.
+type terminalState struct{ termios syscall.Termios }
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeRaw switches the terminal to raw mode: no echo, no line editing and no signal keys,
+// so every key press reaches the TUI as it is typed.
+func makeRaw(fd uintptr) (*terminalState, error) {
+	var t syscall.Termios
+	if err := ioctl(fd, ioctlGetTermios, unsafe.Pointer(&t)); err != nil { //nolint:gosec // termios ioctl
+		return nil, err
+	}
+	saved := &terminalState{termios: t}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlSetTermios, unsafe.Pointer(&t)); err != nil { //nolint:gosec // termios ioctl
+		return nil, err
+	}
+	return saved, nil
+}
+
+// restoreTerminal puts back the mode saved by makeRaw.
+func restoreTerminal(fd uintptr, s *terminalState) error {
+	return ioctl(fd, ioctlSetTermios, unsafe.Pointer(&s.termios)) //nolint:gosec // termios ioctl
+}
+
+// terminalSize returns the width and height of the terminal in characters.
+func terminalSize(fd uintptr) (width, height int, err error) {
+	var ws struct{ Row, Col, X, Y uint16 }
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil { //nolint:gosec // winsize ioctl
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+// notifyResize delivers a signal on ch whenever the terminal is resized.
+func notifyResize(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGWINCH)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// byteRateUnits are the suffixes accepted by parseByteRate, in bytes. Byte units are
+// binary like formatBytes; bit units follow the decimal convention of link speeds.
+var byteRateUnits = []struct {
+	suffix string
+	scale  float64
+}{
+	{"kbit", 1000.0 / 8},
+	{"mbit", 1000 * 1000.0 / 8},
+	{"gbit", 1000 * 1000 * 1000.0 / 8},
+	{"kb", 1 << 10},
+	{"mb", 1 << 20},
+	{"gb", 1 << 30},
+	{"k", 1 << 10},
+	{"m", 1 << 20},
+	{"g", 1 << 30},
+	{"b", 1},
+}
+
+// parseByteRate parses a bandwidth per second such as "64KB", "1.5M" or "750kbit" into
+// bytes per second. An empty string or "0" means unlimited.
+func parseByteRate(rate string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(rate)), "/s")
+	if s == "" {
+		return 0, nil
+	}
+	scale := 1.0
+	for _, u := range byteRateUnits {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, scale = num, u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q: expected bytes per second, e.g. 64KB or 750kbit", rate)
+	}
+	return int64(n * scale), nil
+}
+
+// rateLimiter paces a byte stream to a fixed rate. Each read reserves its share of the
+// schedule, so concurrent streams sharing a limiter split the bandwidth between them.
+// A nil *rateLimiter does not limit.
+type rateLimiter struct {
+	rate int64 // bytes per second
+
+	mu   sync.Mutex
+	next time.Time // when the bytes reserved so far have been sent
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate}
+}
+
+// reserve books n bytes and returns the time by which they may have been sent.
+func (l *rateLimiter) reserve(n int) time.Time {
+	now := time.Now()
+	if l == nil {
+		return now
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.next.Before(now) {
+		l.next = now // an idle stream does not save up a burst
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	return l.next
+}
+
+// throttle emulates a slow link: it adds a fixed round-trip time to every request and
+// limits request bodies sent upstream and response bodies returned to the client, per
+// client connection and across all of them. A nil *throttle changes nothing.
+type throttle struct {
+	rtt       time.Duration
+	up, down  int64 // per-connection bytes per second, 0 for unlimited
+	upTotal   *rateLimiter
+	downTotal *rateLimiter
+}
+
+// newThrottle returns a throttle for the given per-connection and total rates in bytes
+// per second (0 for unlimited), or nil when nothing is limited.
+func newThrottle(rtt time.Duration, up, down, upTotal, downTotal int64) *throttle {
+	if rtt <= 0 && up <= 0 && down <= 0 && upTotal <= 0 && downTotal <= 0 {
+		return nil
+	}
+	return &throttle{
+		rtt:       rtt,
+		up:        up,
+		down:      down,
+		upTotal:   newRateLimiter(upTotal),
+		downTotal: newRateLimiter(downTotal),
+	}
+}
+
+// String describes the configured limits for the startup banner.
+func (th *throttle) String() string {
+	var parts []string
+	if th.rtt > 0 {
+		parts = append(parts, "rtt +"+th.rtt.String())
+	}
+	for _, limit := range []struct {
+		name string
+		rate int64
+	}{
+		{"up", th.up},
+		{"down", th.down},
+		{"up total", th.upTotal.limit()},
+		{"down total", th.downTotal.limit()},
+	} {
+		if limit.rate > 0 {
+			parts = append(parts, limit.name+" "+formatBytes(limit.rate)+"/s")
+		}
+	}
+	return "throttling: " + strings.Join(parts, ", ")
+}
+
+// limit returns the rate of l in bytes per second, 0 for a nil limiter.
+func (l *rateLimiter) limit() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.rate
+}
+
+// connLimits are the limiters of one client connection.
+type connLimits struct {
+	up, down *rateLimiter
+}
+
+type connLimitsKey struct{}
+
+// ConnContext is the http.Server hook that gives every client connection its own limits.
+func (th *throttle) ConnContext(ctx context.Context, _ net.Conn) context.Context {
+	if th == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, connLimitsKey{}, &connLimits{up: newRateLimiter(th.up), down: newRateLimiter(th.down)})
+}
+
+// limits returns the connection limits for a request, or fresh ones when it did not come
+// through a server using ConnContext.
+func (th *throttle) limits(ctx context.Context) *connLimits {
+	if cl, ok := ctx.Value(connLimitsKey{}).(*connLimits); ok {
+		return cl
+	}
+	return &connLimits{up: newRateLimiter(th.up), down: newRateLimiter(th.down)}
+}
+
+// Delay waits out the added round-trip time before a request is forwarded. The client
+// connection's deadlines restart afterwards, so the wait does not count against them.
+func (th *throttle) Delay(ctx context.Context) error {
+	if th == nil || th.rtt <= 0 {
+		return nil
+	}
+	err := sleepUntil(ctx, time.Now().Add(th.rtt))
+	deadlinesFrom(ctx).Extend()
+	return err
+}
+
+// Upload paces a request body on its way upstream. The body was already read from the
+// client, so the client connection's deadlines are extended as the upload progresses;
+// otherwise a slow upload would outlast the server's ReadTimeout and be canceled.
+func (th *throttle) Upload(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	if th == nil || body == nil || body == http.NoBody {
+		return body
+	}
+	return deadlinesFrom(ctx).Track(newThrottledBody(ctx, body, th.limits(ctx).up, th.upTotal))
+}
+
+// Download paces a response body on its way to the client. RoundTrip extends the
+// deadlines of the client connection while it streams.
+func (th *throttle) Download(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	if th == nil {
+		return body
+	}
+	return newThrottledBody(ctx, body, th.limits(ctx).down, th.downTotal)
+}
+
+// throttledBody reads in small chunks and sleeps after each one until every limiter has
+// room for it, so data arrives at a steady pace rather than in bursts.
+type throttledBody struct {
+	io.ReadCloser
+	ctx      context.Context
+	limiters []*rateLimiter
+	chunk    int
+}
+
+func newThrottledBody(ctx context.Context, rc io.ReadCloser, limiters ...*rateLimiter) io.ReadCloser {
+	tb := &throttledBody{ReadCloser: rc, ctx: ctx, chunk: 32 << 10}
+	for _, l := range limiters {
+		if l != nil {
+			tb.limiters = append(tb.limiters, l)
+			// Twenty chunks per second keep the pace smooth even for slow links.
+			tb.chunk = min(tb.chunk, max(int(l.rate/20), 1))
+		}
+	}
+	if len(tb.limiters) == 0 {
+		return rc
+	}
+	return tb
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > b.chunk {
+		p = p[:b.chunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		var until time.Time
+		for _, l := range b.limiters {
+			if t := l.reserve(n); t.After(until) {
+				until = t
+			}
+		}
+		if serr := sleepUntil(b.ctx, until); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return n, err
+}
+
+// sleepUntil waits until t or until ctx is done.
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throughput returns the effective download rate of a finished exchange in bytes per
+// second, measured over the body transfer, or 0 when it cannot be measured.
+func throughput(ex *exchange) float64 {
+	if ex.RespSize == 0 || ex.Timings.Transfer <= 0 {
+		return 0
+	}
+	return float64(ex.RespSize) / ex.Timings.Transfer.Seconds()
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseByteRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{in: "", want: 0},
+		{in: "512", want: 512},
+		{in: "64KB", want: 64 << 10},
+		{in: "64k/s", want: 64 << 10},
+		{in: "1.5M", want: 3 << 19},
+		{in: "750kbit", want: 93750},
+		{in: "2mbit", want: 250000},
+		{in: "100B", want: 100},
+	}
+	for _, tt := range tests {
+		got, err := parseByteRate(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteRate(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"fast", "-1KB", "KB"} {
+		if _, err := parseByteRate(in); err == nil {
+			t.Errorf("parseByteRate(%q) expected error", in)
+		}
+	}
+}
+
+func TestNewThrottle(t *testing.T) {
+	if th := newThrottle(0, 0, 0, 0, 0); th != nil {
+		t.Errorf("newThrottle without limits = %+v, want nil", th)
+	}
+	th := newThrottle(300*time.Millisecond, 0, 64<<10, 0, 1<<20)
+	if got := th.String(); got != "throttling: rtt +300ms, down 64.0 KB/s, down total 1.0 MB/s" {
+		t.Errorf("String() = %q", got)
+	}
+	var none *throttle
+	body := io.NopCloser(strings.NewReader("x"))
+	if none.Download(context.Background(), body) != body || none.Delay(context.Background()) != nil {
+		t.Error("nil throttle changed the body or waited")
+	}
+}
+
+func TestThrottledBodyPace(t *testing.T) {
+	read := func(rc io.ReadCloser) time.Duration {
+		start := time.Now()
+		if _, err := io.Copy(io.Discard, rc); err != nil {
+			t.Error(err)
+		}
+		return time.Since(start)
+	}
+
+	body := newThrottledBody(context.Background(), io.NopCloser(bytes.NewReader(make([]byte, 2000))), newRateLimiter(10000))
+	if elapsed := read(body); elapsed < 150*time.Millisecond {
+		t.Errorf("2000 B at 10000 B/s took %s, want about 200ms", elapsed)
+	}
+
+	// Two streams sharing a total limit split it between them.
+	total := newRateLimiter(10000)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			read(newThrottledBody(context.Background(), io.NopCloser(bytes.NewReader(make([]byte, 1000))), nil, total))
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("2 x 1000 B sharing 10000 B/s took %s, want about 200ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := newThrottledBody(ctx, io.NopCloser(bytes.NewReader(make([]byte, 100))), newRateLimiter(1))
+	if _, err := io.ReadAll(slow); err == nil {
+		t.Error("canceled read did not fail")
+	}
+}
+
+func TestThrottleThroughProxy(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var uploadTime time.Duration
+	var mu sync.Mutex
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, _ = io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		uploadTime = time.Since(start)
+		mu.Unlock()
+		_, _ = w.Write(make([]byte, 4000))
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	transport := DebugTransport{Throttle: newThrottle(50*time.Millisecond, 10000, 20000, 0, 0)}
+	proxy := httptest.NewUnstartedServer(&httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite:   func(pr *httputil.ProxyRequest) { pr.SetURL(target) },
+	})
+	proxy.Config.ConnContext = transport.Throttle.ConnContext
+	proxy.Start()
+	defer proxy.Close()
+
+	start := time.Now()
+	resp, err := http.Post(proxy.URL+"/upload", "application/octet-stream", bytes.NewReader(make([]byte, 2000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	elapsed := time.Since(start)
+	if len(body) != 4000 {
+		t.Fatalf("got %d bytes, want 4000", len(body))
+	}
+	// 50ms RTT + 2000 B up at 10000 B/s + 4000 B down at 20000 B/s.
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("throttled exchange took %s, want about 450ms", elapsed)
+	}
+	mu.Lock()
+	if uploadTime < 150*time.Millisecond {
+		t.Errorf("upstream received the body in %s, want about 200ms", uploadTime)
+	}
+	mu.Unlock()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(buf.String(), "--- RESPONSE") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !regexp.MustCompile(`--- RESPONSE \d+ \(200 OK\) .*\) \d+\.\d KB/s ---`).MatchString(buf.String()) {
+		t.Errorf("response marker does not show the throughput:\n%s", buf.String())
+	}
+}
+
+func TestThrottleOutlastsServerTimeouts(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = w.Write(make([]byte, 1000))
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	// 1000 B at 2500 B/s take about 400ms in either direction, well past the timeout.
+	transport := DebugTransport{Throttle: newThrottle(0, 2500, 2500, 0, 0)}
+	proxy := newTimeoutProxy(transport, target, 150*time.Millisecond, true)
+	defer proxy.Close()
+
+	start := time.Now()
+	resp, err := http.Post(proxy.URL, "application/octet-stream", bytes.NewReader(make([]byte, 1000)))
+	if err != nil {
+		t.Fatalf("throttled upload: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || time.Since(start) < 300*time.Millisecond {
+		t.Errorf("throttled upload: %d after %s", resp.StatusCode, time.Since(start))
+	}
+
+	start = time.Now()
+	resp, err = http.Get(proxy.URL)
+	if err != nil {
+		t.Fatalf("throttled download: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil || len(body) != 1000 || time.Since(start) < 300*time.Millisecond {
+		t.Errorf("throttled download: %d bytes after %s, err %v", len(body), time.Since(start), err)
+	}
+}
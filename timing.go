@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// phaseTimings is the upstream connection breakdown of a single exchange, collected
+// via net/http/httptrace. Phases that did not happen (e.g. DNS on a reused connection) are zero.
+type phaseTimings struct {
+	DNS      time.Duration // DNS lookup
+	Connect  time.Duration // TCP connect
+	TLS      time.Duration // TLS handshake
+	TTFB     time.Duration // request sent upstream until the first response byte
+	Transfer time.Duration // response headers until the body completed
+	Reused   bool          // an idle keep-alive connection was reused
+}
+
+// phaseTracer records httptrace callbacks. Callbacks may fire from several goroutines
+// (e.g. parallel dial attempts), so access is guarded by a mutex.
+type phaseTracer struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	reused       bool
+}
+
+// newPhaseTracer returns a tracer whose TTFB is measured from now.
+func newPhaseTracer() *phaseTracer {
+	return &phaseTracer{start: time.Now()}
+}
+
+// ClientTrace returns the httptrace hooks feeding the tracer.
+func (p *phaseTracer) ClientTrace() *httptrace.ClientTrace {
+	set := func(dst *time.Time, onlyFirst bool) {
+		p.mu.Lock()
+		if !onlyFirst || dst.IsZero() {
+			*dst = time.Now()
+		}
+		p.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { set(&p.dnsStart, true) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { set(&p.dnsDone, false) },
+		ConnectStart:         func(_, _ string) { set(&p.connectStart, true) },
+		ConnectDone:          func(_, _ string, _ error) { set(&p.connectDone, false) },
+		TLSHandshakeStart:    func() { set(&p.tlsStart, true) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { set(&p.tlsDone, false) },
+		GotFirstResponseByte: func() { set(&p.firstByte, true) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			p.mu.Lock()
+			p.reused = info.Reused
+			p.mu.Unlock()
+		},
+	}
+}
+
+// Timings converts the recorded events into phase durations.
+func (p *phaseTracer) Timings() phaseTimings {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	span := func(from, to time.Time) time.Duration {
+		if from.IsZero() || to.IsZero() || to.Before(from) {
+			return 0
+		}
+		return to.Sub(from)
+	}
+	return phaseTimings{
+		DNS:     span(p.dnsStart, p.dnsDone),
+		Connect: span(p.connectStart, p.connectDone),
+		TLS:     span(p.tlsStart, p.tlsDone),
+		TTFB:    span(p.start, p.firstByte),
+		Reused:  p.reused,
+	}
+}
+
+// formatMillis renders a duration as fractional milliseconds, e.g. "12.3ms".
+func formatMillis(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", millis(d))
+}
+
+// String lists the non-zero phases, e.g. "dns 1.2ms, connect 0.8ms, ttfb 40.1ms, transfer 2.0ms".
+func (pt phaseTimings) String() string {
+	var parts []string
+	if pt.Reused {
+		parts = append(parts, "reused")
+	}
+	for _, ph := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"dns", pt.DNS},
+		{"connect", pt.Connect},
+		{"tls", pt.TLS},
+		{"ttfb", pt.TTFB},
+		{"transfer", pt.Transfer},
+	} {
+		if ph.d > 0 {
+			parts = append(parts, ph.name+" "+formatMillis(ph.d))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// colorDuration picks a color for the total exchange duration relative to the -slow threshold:
+// green below half of it, yellow up to it and red beyond, the same palette colorStatus uses.
+func colorDuration(d, slow time.Duration) string {
+	switch {
+	case slow <= 0 || d < slow/2:
+		return colorStatus2xx
+	case d < slow:
+		return colorStatus4xx
+	default:
+		return colorStatus5xx
+	}
+}
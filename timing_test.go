@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPhaseTimingsString(t *testing.T) {
+	tests := []struct {
+		name string
+		pt   phaseTimings
+		want string
+	}{
+		{name: "empty", pt: phaseTimings{}, want: ""},
+		{
+			name: "fresh connection",
+			pt:   phaseTimings{DNS: 1200 * time.Microsecond, Connect: time.Millisecond, TTFB: 40 * time.Millisecond, Transfer: 2 * time.Millisecond},
+			want: "dns 1.2ms, connect 1.0ms, ttfb 40.0ms, transfer 2.0ms",
+		},
+		{
+			name: "reused connection",
+			pt:   phaseTimings{Reused: true, TTFB: 5 * time.Millisecond},
+			want: "reused, ttfb 5.0ms",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pt.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColorDuration(t *testing.T) {
+	slow := time.Second
+	if colorDuration(100*time.Millisecond, slow) != colorStatus2xx {
+		t.Errorf("expected fast color")
+	}
+	if colorDuration(700*time.Millisecond, slow) != colorStatus4xx {
+		t.Errorf("expected warning color")
+	}
+	if colorDuration(2*time.Second, slow) != colorStatus5xx {
+		t.Errorf("expected slow color")
+	}
+	if colorDuration(time.Hour, 0) != colorStatus2xx {
+		t.Errorf("expected threshold 0 to disable highlighting")
+	}
+}
+
+func TestSendTracesPhases(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	req, err := http.NewRequest(http.MethodGet, upstream.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex := &exchange{Request: req, Start: time.Now()}
+	resp, err := DebugTransport{}.send(ex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if ex.Timings.TTFB < 20*time.Millisecond {
+		t.Errorf("ttfb = %v, want at least the upstream delay", ex.Timings.TTFB)
+	}
+	if ex.Timings.Connect <= 0 && !ex.Timings.Reused {
+		t.Errorf("connect phase not traced: %+v", ex.Timings)
+	}
+}
+
+func TestResponseMarker(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	start := time.Now()
+	ex := &exchange{
+		ID:       4,
+		Response: &http.Response{Status: "200 OK"},
+		Start:    start,
+		End:      start.Add(1500 * time.Microsecond),
+		Timings:  phaseTimings{TTFB: time.Millisecond},
+		Labels:   []string{labelReplayed},
+	}
+	got := responseMarker(ex)
+	if !strings.HasPrefix(got, "--- RESPONSE 4 (200 OK) 1.5ms (ttfb 1.0ms) [replayed] ---") {
+		t.Errorf("unexpected marker %q", got)
+	}
+}
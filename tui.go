@@ -0,0 +1,569 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Terminal control sequences used by the TUI.
+const (
+	termAltScreen  = "\033[?1049h"
+	termMainScreen = "\033[?1049l"
+	termHideCursor = "\033[?25l"
+	termShowCursor = "\033[?25h"
+	termClearLine  = "\033[K"
+	termReverse    = "\033[7m"
+)
+
+const tuiHelp = "↑↓ select  PgUp/PgDn scroll  g/G first/last  / search  n next  f filter  r raw  c copy curl  q quit"
+
+// tui is the interactive terminal UI started by -tui. It receives the same finished exchanges
+// as the line-oriented log and shows them in a navigable list above a detail pane with the
+// highlighted request and response. A nil *tui records nothing.
+type tui struct {
+	max   int
+	mu    sync.Mutex
+	all   []*exchange // oldest first, at most max
+	dirty chan struct{}
+
+	// The fields below are owned by the Run loop.
+	view       []*exchange // all, narrowed by the filter
+	filter     *exchangeFilter
+	filterText string
+	selected   int  // index into view, -1 when empty
+	follow     bool // keep the newest exchange selected
+	listTop    int  // first visible row of the list
+	scroll     int  // first visible line of the detail pane
+	pageSize   int  // height of the detail pane in the last frame
+	raw        bool // show bodies as received instead of pretty-printed
+	search     string
+	prompt     string // label of the line being edited, e.g. "filter"
+	input      string
+	status     string
+	quit       bool
+	clipboard  io.Writer
+	bodies     *bodyStore // binary request bodies for copied curl commands
+
+	detailKey   tuiDetailKey
+	detailLines []string
+}
+
+// tuiDetailKey identifies the rendered detail pane so it is only rebuilt when it changes.
+type tuiDetailKey struct {
+	id    int64
+	raw   bool
+	width int
+}
+
+func newTUI(size int) *tui {
+	return &tui{max: size, dirty: make(chan struct{}, 1), selected: -1, follow: true}
+}
+
+// Add stores a finished exchange, dropping the oldest one beyond the buffer size.
+func (t *tui) Add(ex *exchange) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.all = append(t.all, ex)
+	if len(t.all) > t.max {
+		t.all = t.all[len(t.all)-t.max:]
+	}
+	t.mu.Unlock()
+	select {
+	case t.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// Run takes over the terminal until q is pressed or ctx is done. in must be a terminal;
+// its mode is restored on return.
+func (t *tui) Run(ctx context.Context, in *os.File, out io.Writer) error {
+	state, err := makeRaw(in.Fd())
+	if err != nil {
+		return fmt.Errorf("-tui needs an interactive terminal: %w", err)
+	}
+	defer func() { _ = restoreTerminal(in.Fd(), state) }()
+	_, _ = io.WriteString(out, termAltScreen+termHideCursor)
+	defer func() { _, _ = io.WriteString(out, termShowCursor+termMainScreen) }()
+
+	keys := make(chan []string)
+	go readKeys(in, keys)
+	resize := make(chan os.Signal, 1)
+	notifyResize(resize)
+	t.clipboard = out
+
+	for !t.quit {
+		width, height, err := terminalSize(in.Fd())
+		if err != nil {
+			width, height = 80, 24
+		}
+		t.refresh()
+		_, _ = io.WriteString(out, t.frame(width, height))
+		select {
+		case <-ctx.Done():
+			return nil
+		case ks, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, k := range ks {
+				t.handleKey(k)
+			}
+		case <-t.dirty:
+		case <-resize:
+		}
+	}
+	return nil
+}
+
+// readKeys sends the keys of every read from in until it fails.
+func readKeys(in io.Reader, keys chan<- []string) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			keys <- parseKeys(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// tuiEscapeKeys maps terminal escape sequences to key names.
+var tuiEscapeKeys = map[string]string{
+	"\033[A": "up", "\033OA": "up",
+	"\033[B": "down", "\033OB": "down",
+	"\033[5~": "pgup", "\033[6~": "pgdn",
+	"\033[H": "home", "\033OH": "home", "\033[1~": "home",
+	"\033[F": "end", "\033OF": "end", "\033[4~": "end",
+}
+
+// parseKeys splits terminal input into key names ("up", "enter", "ctrl-c", ...) and
+// typed characters. Unknown escape sequences and control characters are dropped.
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch c := b[0]; {
+		case c == '\033':
+			if len(b) == 1 {
+				return append(keys, "esc")
+			}
+			end := 2
+			if b[1] == '[' || b[1] == 'O' {
+				for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+					end++
+				}
+				end = min(end+1, len(b))
+			}
+			if k, ok := tuiEscapeKeys[string(b[:end])]; ok {
+				keys = append(keys, k)
+			}
+			b = b[end:]
+		case c == '\r' || c == '\n':
+			keys, b = append(keys, "enter"), b[1:]
+		case c == 0x7f || c == 0x08:
+			keys, b = append(keys, "backspace"), b[1:]
+		case c == 0x03:
+			keys, b = append(keys, "ctrl-c"), b[1:]
+		case c < 0x20:
+			b = b[1:]
+		default:
+			r, size := utf8.DecodeRune(b)
+			keys, b = append(keys, string(r)), b[size:]
+		}
+	}
+	return keys
+}
+
+// refresh applies the filter to the buffered exchanges, keeping the selection on the same
+// exchange, or on the newest one in follow mode.
+func (t *tui) refresh() {
+	var current int64 = -1
+	if t.selected >= 0 && t.selected < len(t.view) {
+		current = t.view[t.selected].ID
+	}
+	t.mu.Lock()
+	all := append([]*exchange(nil), t.all...)
+	t.mu.Unlock()
+
+	t.view = t.view[:0]
+	t.selected = -1
+	for _, ex := range all {
+		if !t.matches(ex) {
+			continue
+		}
+		if ex.ID == current {
+			t.selected = len(t.view)
+		}
+		t.view = append(t.view, ex)
+	}
+	if t.follow || t.selected == -1 {
+		t.selected = len(t.view) - 1
+	}
+}
+
+// matches applies the list filter, which uses the -filter expression syntax.
+func (t *tui) matches(ex *exchange) bool {
+	if t.filter == nil {
+		return true
+	}
+	s := newUISummary(ex)
+	return t.filter.MatchRequest(ex.Request) && t.filter.MatchResponse(&http.Response{StatusCode: s.Status})
+}
+
+func (t *tui) handleKey(k string) {
+	if t.prompt != "" {
+		t.editPrompt(k)
+		return
+	}
+	t.status = ""
+	switch k {
+	case "q", "ctrl-c":
+		t.quit = true
+	case "up", "k":
+		t.selectIndex(t.selected - 1)
+	case "down", "j":
+		t.selectIndex(t.selected + 1)
+	case "home", "g":
+		t.selectIndex(0)
+	case "end", "G":
+		t.selectIndex(len(t.view) - 1)
+	case "pgdn", " ":
+		t.scroll += max(t.pageSize-1, 1)
+	case "pgup", "b":
+		t.scroll = max(t.scroll-max(t.pageSize-1, 1), 0)
+	case "r":
+		t.raw = !t.raw
+	case "c":
+		t.copyCurl()
+	case "f":
+		t.prompt, t.input = "filter", t.filterText
+	case "/":
+		t.prompt, t.input = "search", t.search
+	case "n":
+		t.findNext()
+	}
+}
+
+func (t *tui) editPrompt(k string) {
+	switch k {
+	case "enter":
+		prompt, input := t.prompt, strings.TrimSpace(t.input)
+		t.prompt, t.input = "", ""
+		if prompt == "filter" {
+			t.applyFilter(input)
+		} else {
+			t.search = input
+			t.findNext()
+		}
+	case "esc", "ctrl-c":
+		t.prompt, t.input = "", ""
+	case "backspace":
+		if _, size := utf8.DecodeLastRuneInString(t.input); size > 0 {
+			t.input = t.input[:len(t.input)-size]
+		}
+	default:
+		if utf8.RuneCountInString(k) == 1 {
+			t.input += k
+		}
+	}
+}
+
+// applyFilter parses space-separated -filter expressions such as "method=POST status=5xx".
+func (t *tui) applyFilter(text string) {
+	if text == "" {
+		t.filter, t.filterText = nil, ""
+		return
+	}
+	f, err := newExchangeFilter(strings.Fields(text), nil, nil)
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	t.filter, t.filterText = f, text
+	t.refresh()
+}
+
+func (t *tui) selectIndex(i int) {
+	if len(t.view) == 0 {
+		return
+	}
+	i = max(0, min(i, len(t.view)-1))
+	if i != t.selected {
+		t.scroll = 0
+	}
+	t.selected = i
+	t.follow = i == len(t.view)-1
+}
+
+// findNext selects the next exchange after the current one whose URL, headers or bodies
+// contain the search text, wrapping around at the end of the list.
+func (t *tui) findNext() {
+	if t.search == "" || len(t.view) == 0 {
+		return
+	}
+	needle := strings.ToLower(t.search)
+	for step := 1; step <= len(t.view); step++ {
+		i := (t.selected + step) % len(t.view)
+		if strings.Contains(strings.ToLower(tuiSearchText(t.view[i])), needle) {
+			t.selectIndex(i)
+			t.status = fmt.Sprintf("found %q in exchange %d", t.search, t.view[i].ID)
+			return
+		}
+	}
+	t.status = fmt.Sprintf("%q not found", t.search)
+}
+
+func tuiSearchText(ex *exchange) string {
+	r := ex.render(plainPainter, true)
+	return ex.Request.URL.String() + "\n" + r.RequestHeaders + "\n" + r.RequestBody + "\n" + r.ResponseHeaders + "\n" + r.ResponseBody
+}
+
+// copyCurl puts the selected request on the clipboard as a curl command. The OSC 52
+// sequence is handled by the terminal emulator, so it also works over ssh.
+func (t *tui) copyCurl() {
+	if t.selected < 0 || t.clipboard == nil {
+		return
+	}
+	ex := t.view[t.selected]
+	file, err := t.bodies.Save(ex)
+	_, _ = io.WriteString(t.clipboard, "\033]52;c;"+base64.StdEncoding.EncodeToString([]byte(curlCommand(ex, file)))+"\a")
+	t.status = fmt.Sprintf("copied exchange %d as curl", ex.ID)
+	if err != nil {
+		t.status += fmt.Sprintf(" (body inline: %v)", err)
+	}
+}
+
+// frame renders the whole screen: a title line, the exchange list, the detail pane and a
+// status line at the bottom.
+func (t *tui) frame(width, height int) string {
+	width, height = max(width, 20), max(height, 8)
+	listHeight := max((height-3)*2/5, 3)
+	detailHeight := height - 3 - listHeight
+	t.pageSize = detailHeight
+
+	lines := make([]string, 0, height)
+	title := fmt.Sprintf(" http-proxy-logger  exchanges: %d", len(t.view))
+	if t.filterText != "" {
+		title += "  filter: " + t.filterText
+	}
+	if t.raw {
+		title += "  [raw]"
+	}
+	lines = append(lines, reverseLine(padANSI(title, width)))
+
+	t.listTop = max(min(t.listTop, t.selected), t.selected-listHeight+1, 0)
+	for row := t.listTop; row < t.listTop+listHeight; row++ {
+		switch {
+		case row >= len(t.view):
+			lines = append(lines, "")
+		case row == t.selected:
+			lines = append(lines, reverseLine(padANSI(tuiRow(t.view[row], false), width)))
+		default:
+			lines = append(lines, truncateANSI(tuiRow(t.view[row], true), width))
+		}
+	}
+
+	detail := t.detail(width)
+	t.scroll = max(min(t.scroll, len(detail)-detailHeight), 0)
+	heading := "──"
+	if t.selected >= 0 {
+		heading += fmt.Sprintf(" exchange %d ", t.view[t.selected].ID)
+		if len(detail) > detailHeight {
+			heading += fmt.Sprintf("(lines %d-%d of %d) ", t.scroll+1, min(t.scroll+detailHeight, len(detail)), len(detail))
+		}
+	}
+	lines = append(lines, wrapColor(padRunes(heading, width, '─'), colorTime))
+	for i := t.scroll; i < t.scroll+detailHeight; i++ {
+		if i < len(detail) {
+			lines = append(lines, detail[i])
+		} else {
+			lines = append(lines, "")
+		}
+	}
+
+	switch {
+	case t.prompt != "":
+		lines = append(lines, truncateANSI(t.prompt+": "+t.input+"█", width))
+	case t.status != "":
+		lines = append(lines, truncateANSI(t.status, width))
+	default:
+		lines = append(lines, wrapColor(truncateANSI(tuiHelp, width), colorTime))
+	}
+
+	var b strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&b, "\033[%d;1H%s%s", i+1, line, termClearLine)
+	}
+	return b.String()
+}
+
+// reverseLine highlights a whole line, such as the selected row. Without colors the row
+// marker in the first column shows the selection instead.
+func reverseLine(line string) string {
+	return wrapColor(line, termReverse)
+}
+
+// tuiRow formats one list row: id, time, method, status, size, duration and URL.
+func tuiRow(ex *exchange, colored bool) string {
+	s := newUISummary(ex)
+	status := strconv.Itoa(s.Status)
+	if s.Error != "" {
+		status += " " + s.Error
+	}
+	marker := "  "
+	if !colored {
+		marker = "> "
+	}
+	method, code := fmt.Sprintf("%-7s", s.Method), fmt.Sprintf("%-3s", status)
+	if colored {
+		method, code = wrapColor(method, colorMethod), wrapColor(code, colorStatus(s.Status))
+	}
+	return fmt.Sprintf("%s%5d %s %s %s %9s %9s  %s%s%s", marker, s.ID, s.Start.Format(time.TimeOnly), method, code,
+		formatBytes(s.Size), formatMillis(ex.Duration()), s.Host, s.Path, ex.labelSuffix())
+}
+
+// detail returns the wrapped lines of the selected exchange, rendered like the log.
+func (t *tui) detail(width int) []string {
+	if t.selected < 0 {
+		return []string{"No exchanges yet."}
+	}
+	ex := t.view[t.selected]
+	key := tuiDetailKey{id: ex.ID, raw: t.raw, width: width}
+	if key == t.detailKey && t.detailLines != nil {
+		return t.detailLines
+	}
+	r := ex.render(ansiPainter, t.raw)
+	var b strings.Builder
+	b.WriteString(wrapColor(fmt.Sprintf("--- REQUEST %d%s%s ---", ex.ID, tlsSuffix(ex.Request.TLS), ex.labelSuffix()), colorReqMarker))
+	b.WriteString("\n\n" + r.RequestHeaders + "\n")
+	if r.RequestBody != "" {
+		b.WriteString("\n" + r.RequestBody + "\n")
+	}
+	if ex.Response != nil {
+		b.WriteString("\n" + responseMarker(ex) + "\n\n" + r.ResponseHeaders + "\n")
+		if r.ResponseBody != "" {
+			b.WriteString("\n" + r.ResponseBody + "\n")
+		}
+	} else if ex.Err != nil {
+		class, _ := classifyError(ex.Err)
+		b.WriteString("\n" + wrapColor(fmt.Sprintf("--- ERROR %d (%s) ---", ex.ID, class), colorStatus5xx) + "\n\n" +
+			wrapColor(ex.Err.Error(), colorStatus5xx) + "\n")
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(b.String(), "\r\n", "\n"), "\n") {
+		lines = append(lines, wrapANSI(line, width)...)
+	}
+	t.detailKey, t.detailLines = key, lines
+	return lines
+}
+
+// formatBytes renders a size as B, KB or MB.
+func formatBytes(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/1024/1024)
+	}
+}
+
+// wrapANSI splits s into lines of at most width visible characters. Color escapes are not
+// counted, and a color that spans a break is carried over to the next line.
+func wrapANSI(s string, width int) []string {
+	var lines []string
+	var b strings.Builder
+	active, visible := "", 0
+	for i := 0; i < len(s); {
+		if seq := ansiSequence(s[i:]); seq != "" {
+			b.WriteString(seq)
+			active = seq
+			if seq == colorReset {
+				active = ""
+			}
+			i += len(seq)
+			continue
+		}
+		if visible == width {
+			if active != "" {
+				b.WriteString(colorReset)
+			}
+			lines = append(lines, b.String())
+			b.Reset()
+			b.WriteString(active)
+			visible = 0
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(s[i : i+size])
+		visible++
+		i += size
+	}
+	return append(lines, b.String())
+}
+
+// truncateANSI cuts s after width visible characters, keeping its color escapes intact.
+func truncateANSI(s string, width int) string {
+	lines := wrapANSI(s, width)
+	if len(lines) > 1 && strings.Contains(lines[0], "\033[") && !strings.HasSuffix(lines[0], colorReset) {
+		return lines[0] + colorReset
+	}
+	return lines[0]
+}
+
+// padANSI truncates or pads s with spaces to exactly width visible characters.
+func padANSI(s string, width int) string {
+	s = truncateANSI(s, width)
+	return s + strings.Repeat(" ", max(width-visibleWidth(s), 0))
+}
+
+func padRunes(s string, width int, fill rune) string {
+	n := utf8.RuneCountInString(s)
+	if n >= width {
+		return s
+	}
+	return s + strings.Repeat(string(fill), width-n)
+}
+
+func visibleWidth(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		if seq := ansiSequence(s[i:]); seq != "" {
+			i += len(seq)
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n++
+	}
+	return n
+}
+
+// ansiSequence returns the SGR escape sequence at the start of s, if any.
+func ansiSequence(s string) string {
+	if !strings.HasPrefix(s, "\033[") {
+		return ""
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i] == 'm' {
+			return s[:i+1]
+		}
+		if (s[i] < '0' || s[i] > '9') && s[i] != ';' {
+			return ""
+		}
+	}
+	return ""
+}
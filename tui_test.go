@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testExchange(id int64, method, rawURL string, status int, body string) *exchange {
+	req, _ := http.NewRequest(method, rawURL, nil)
+	start := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	resp := &http.Response{StatusCode: status, Status: fmt.Sprintf("%d %s", status, http.StatusText(status)), Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header: http.Header{"Content-Type": {"application/json"}}}
+	return &exchange{ID: id, Request: req, Response: resp, RespBody: []byte(body), RespSize: int64(len(body)),
+		Start: start, End: start.Add(12 * time.Millisecond)}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: "jk", want: []string{"j", "k"}},
+		{in: "\033[A\033[B", want: []string{"up", "down"}},
+		{in: "\033OA\033[5~\033[6~", want: []string{"up", "pgup", "pgdn"}},
+		{in: "\033[H\033[4~", want: []string{"home", "end"}},
+		{in: "\033", want: []string{"esc"}},
+		{in: "a\r\x7f\x03", want: []string{"a", "enter", "backspace", "ctrl-c"}},
+		{in: "é\x01\033[99Z", want: []string{"é"}},
+	}
+	for _, tt := range tests {
+		if got := parseKeys([]byte(tt.in)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseKeys(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWrapANSI(t *testing.T) {
+	red := colorStatus5xx
+	tests := []struct {
+		in    string
+		width int
+		want  []string
+	}{
+		{in: "abcdef", width: 4, want: []string{"abcd", "ef"}},
+		{in: "abc", width: 4, want: []string{"abc"}},
+		{in: "", width: 4, want: []string{""}},
+		{in: red + "abcdef" + colorReset, width: 4, want: []string{red + "abcd" + colorReset, red + "ef" + colorReset}},
+		{in: "ab" + red + "c" + colorReset + "de", width: 3, want: []string{"ab" + red + "c" + colorReset, "de"}},
+	}
+	for _, tt := range tests {
+		if got := wrapANSI(tt.in, tt.width); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrapANSI(%q, %d) = %q, want %q", tt.in, tt.width, got, tt.want)
+		}
+	}
+	if got := truncateANSI(red+"abcdef", 3); got != red+"abc"+colorReset {
+		t.Errorf("truncateANSI = %q", got)
+	}
+	if got := padANSI(red+"ab"+colorReset, 4); visibleWidth(got) != 4 {
+		t.Errorf("padANSI width = %d", visibleWidth(got))
+	}
+}
+
+func TestTUINavigation(t *testing.T) {
+	ui := newTUI(3)
+	for id := int64(1); id <= 5; id++ {
+		ui.Add(testExchange(id, http.MethodGet, "http://api.test/items", 200, "{}"))
+	}
+	ui.refresh()
+	if len(ui.view) != 3 || ui.view[0].ID != 3 {
+		t.Fatalf("buffered %d exchanges starting at %d, want 3 starting at 3", len(ui.view), ui.view[0].ID)
+	}
+	if ui.selected != 2 {
+		t.Errorf("follow mode selected %d, want the newest", ui.selected)
+	}
+
+	ui.handleKey("up")
+	ui.Add(testExchange(6, http.MethodGet, "http://api.test/items", 200, "{}"))
+	ui.refresh()
+	if got := ui.view[ui.selected].ID; got != 4 {
+		t.Errorf("selection moved to %d after new traffic, want 4", got)
+	}
+
+	ui.handleKey("G")
+	ui.Add(testExchange(7, http.MethodGet, "http://api.test/items", 200, "{}"))
+	ui.refresh()
+	if got := ui.view[ui.selected].ID; got != 7 {
+		t.Errorf("after G selected %d, want the newest (7)", got)
+	}
+
+	ui.handleKey("q")
+	if !ui.quit {
+		t.Error("q did not quit")
+	}
+}
+
+func TestTUIFilterAndSearch(t *testing.T) {
+	ui := newTUI(10)
+	ui.Add(testExchange(1, http.MethodGet, "http://api.test/users", 200, `{"name":"ada"}`))
+	ui.Add(testExchange(2, http.MethodPost, "http://api.test/orders", 500, `{"error":"needle"}`))
+	ui.Add(testExchange(3, http.MethodGet, "http://api.test/health", 200, `{}`))
+	ui.refresh()
+
+	typeKeys := func(s string) {
+		for _, r := range s {
+			ui.handleKey(string(r))
+		}
+		ui.handleKey("enter")
+	}
+
+	ui.handleKey("f")
+	typeKeys("status=5xx")
+	ui.refresh()
+	if len(ui.view) != 1 || ui.view[0].ID != 2 {
+		t.Fatalf("filter kept %d exchanges", len(ui.view))
+	}
+	ui.handleKey("f")
+	for range "status=5xx" {
+		ui.handleKey("backspace")
+	}
+	typeKeys("bogus")
+	if !strings.Contains(ui.status, "invalid filter") || ui.filterText != "status=5xx" {
+		t.Errorf("invalid filter: status %q, filter %q", ui.status, ui.filterText)
+	}
+	ui.handleKey("f")
+	ui.input = ""
+	ui.handleKey("enter")
+	ui.refresh()
+	if len(ui.view) != 3 {
+		t.Fatalf("clearing the filter kept %d exchanges", len(ui.view))
+	}
+
+	ui.handleKey("/")
+	typeKeys("NEEDLE")
+	if ui.view[ui.selected].ID != 2 {
+		t.Errorf("search selected %d, want 2", ui.view[ui.selected].ID)
+	}
+	ui.search = "missing"
+	ui.handleKey("n")
+	if !strings.Contains(ui.status, "not found") {
+		t.Errorf("status = %q", ui.status)
+	}
+}
+
+func TestTUIFrame(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	ui := newTUI(10)
+	ui.Add(testExchange(1, http.MethodGet, "http://api.test/users", 200, `{"name":"ada"}`))
+	ui.Add(testExchange(2, http.MethodPost, "http://api.test/orders", 201, `{"id":7}`))
+	ui.refresh()
+	ui.handleKey("up")
+
+	frame := ui.frame(100, 40)
+	if n := strings.Count(frame, termClearLine); n != 40 {
+		t.Errorf("frame has %d lines, want 40", n)
+	}
+	for _, want := range []string{
+		"exchanges: 2",
+		">     1 15:04:05 GET     200      14 B    12.0ms  api.test/users",
+		"      2 15:04:05 POST    201",
+		"exchange 1",
+		"--- REQUEST 1 ---",
+		"--- RESPONSE 1 (200 OK)",
+		`"name": "ada"`,
+		"q quit",
+	} {
+		if !strings.Contains(frame, want) {
+			t.Errorf("frame missing %q:\n%s", want, strings.ReplaceAll(frame, termClearLine, "\n"))
+		}
+	}
+
+	ui.handleKey("r")
+	if frame := ui.frame(100, 40); !strings.Contains(frame, `{"name":"ada"}`) || !strings.Contains(frame, "[raw]") {
+		t.Errorf("raw mode does not show the body as received:\n%s", frame)
+	}
+
+	var clipboard bytes.Buffer
+	ui.clipboard = &clipboard
+	ui.handleKey("c")
+	payload := strings.TrimSuffix(strings.TrimPrefix(clipboard.String(), "\033]52;c;"), "\a")
+	cmd, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil || !strings.HasPrefix(string(cmd), "curl http://api.test/users") {
+		t.Errorf("clipboard = %q (%v)", cmd, err)
+	}
+	if !strings.Contains(ui.status, "copied exchange 1") {
+		t.Errorf("status = %q", ui.status)
+	}
+}
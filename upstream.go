@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// upstreamTLSOptions configures how DebugTransport connects to HTTPS upstreams.
+type upstreamTLSOptions struct {
+	CAFile             string // extra trusted roots (PEM), added to the system pool
+	CertFile           string // client certificate for mutual TLS
+	KeyFile            string
+	ServerName         string // SNI and verification name override
+	MinVersion         string // "1.0" … "1.3"
+	InsecureSkipVerify bool
+}
+
+// newUpstreamTransport returns a dedicated transport with the defaults of
+// http.DefaultTransport plus the given TLS settings.
+func newUpstreamTransport(o upstreamTLSOptions) (*http.Transport, error) {
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("http.DefaultTransport is not an *http.Transport")
+	}
+	tr := base.Clone()
+	cfg := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify, //nolint:gosec // explicit opt-in via -insecure-skip-verify
+	}
+	if o.MinVersion != "" {
+		v, err := parseTLSVersion(o.MinVersion)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MinVersion = v
+	}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	switch {
+	case (o.CertFile == "") != (o.KeyFile == ""):
+		return nil, errors.New("-upstream-cert and -upstream-key must be used together")
+	case o.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	tr.TLSClientConfig = cfg
+	return tr, nil
+}
+
+// parseTLSVersion maps "1.0" … "1.3" to the crypto/tls constants.
+func parseTLSVersion(s string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(s), "tls") {
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("invalid TLS version %q: must be 1.0, 1.1, 1.2 or 1.3", s)
+	}
+}
+
+// certDetails summarizes a certificate for diagnostics, e.g.
+// "subject CN=api.internal, issuer CN=Corp CA, expires 2027-01-31 (106 days)".
+func certDetails(c *x509.Certificate, now time.Time) string {
+	days := int(c.NotAfter.Sub(now).Hours() / 24)
+	expiry := fmt.Sprintf("expires %s (%d days)", c.NotAfter.Format(time.DateOnly), days)
+	if now.After(c.NotAfter) {
+		expiry = fmt.Sprintf("expired %s", c.NotAfter.Format(time.DateOnly))
+	}
+	return fmt.Sprintf("subject %s, issuer %s, %s", c.Subject, c.Issuer, expiry)
+}
+
+// logUpstreamTLS prints the upstream certificate of a new TLS connection in -verbose mode.
+// On a verification failure the rejected certificate is shown alongside the error.
+func logUpstreamTLS(id int64, resp *http.Response, err error) {
+	var cert *x509.Certificate
+	version := ""
+	var verifyErr *tls.CertificateVerificationError
+	switch {
+	case err == nil && resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0:
+		cert, version = resp.TLS.PeerCertificates[0], tls.VersionName(resp.TLS.Version)+", "
+	case errors.As(err, &verifyErr) && len(verifyErr.UnverifiedCertificates) > 0:
+		cert = verifyErr.UnverifiedCertificates[0]
+	default:
+		return
+	}
+	color := colorTime
+	line := fmt.Sprintf("--- UPSTREAM TLS %d: %s%s ---", id, version, certDetails(cert, time.Now()))
+	if verifyErr != nil {
+		color = colorStatus5xx
+		line = fmt.Sprintf("--- UPSTREAM TLS %d REJECTED: %s: %v ---", id, certDetails(cert, time.Now()), verifyErr.Err)
+	}
+	logEntry(id, fmt.Sprintf("%s %s\n\n", coloredTime(time.Now(), color), wrapColor(line, color)))
+}
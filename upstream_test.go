@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint16
+		wantErr bool
+	}{
+		{in: "1.2", want: tls.VersionTLS12},
+		{in: "1.3", want: tls.VersionTLS13},
+		{in: "TLS1.0", want: tls.VersionTLS10},
+		{in: "tls11", want: tls.VersionTLS11},
+		{in: "2.0", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTLSVersion(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTLSVersion(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewUpstreamTransportErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for name, o := range map[string]upstreamTLSOptions{
+		"cert without key": {CertFile: "client.pem"},
+		"missing CA file":  {CAFile: filepath.Join(dir, "missing.pem")},
+		"CA without certs": {CAFile: empty},
+		"bad min version":  {MinVersion: "0.9"},
+	} {
+		if _, err := newUpstreamTransport(o); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestUpstreamTransportTLS(t *testing.T) {
+	originalNoColor, originalVerbose := *noColor, *verbose
+	*noColor, *verbose = true, true
+	defer func() { *noColor, *verbose = originalNoColor, originalVerbose }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := "anonymous"
+		if len(r.TLS.PeerCertificates) > 0 {
+			client = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		_, _ = io.WriteString(w, client)
+	}))
+	origin.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	origin.StartTLS()
+	defer origin.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "origin-ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", origin.Certificate().Raw)
+
+	caPEM, caKeyPEM, err := generateCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCA, err := parseCA(caPEM, caKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := testClientCert(t, clientCA, "proxy-client")
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", client.Certificate[0])
+	keyDER, err := x509.MarshalPKCS8PrivateKey(client.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+
+	tests := []struct {
+		name     string
+		opts     upstreamTLSOptions
+		wantBody string // empty: the round trip must fail
+		wantLog  string
+	}{
+		{name: "untrusted", opts: upstreamTLSOptions{}, wantLog: "REJECTED: subject O=Acme Co"},
+		{name: "custom CA", opts: upstreamTLSOptions{CAFile: caFile}, wantBody: "anonymous", wantLog: "issuer O=Acme Co"},
+		{name: "SNI override", opts: upstreamTLSOptions{CAFile: caFile, ServerName: "example.com"}, wantBody: "anonymous"},
+		{name: "SNI mismatch", opts: upstreamTLSOptions{CAFile: caFile, ServerName: "wrong.test"}},
+		{name: "insecure", opts: upstreamTLSOptions{InsecureSkipVerify: true}, wantBody: "anonymous"},
+		{name: "mutual TLS", opts: upstreamTLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, wantBody: "proxy-client"},
+		{name: "min version", opts: upstreamTLSOptions{CAFile: caFile, MinVersion: "1.3"}, wantBody: "anonymous", wantLog: "TLS 1.3, subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, err := newUpstreamTransport(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer upstream.CloseIdleConnections()
+			seen := len(buf.String())
+
+			req, _ := http.NewRequest(http.MethodGet, origin.URL+"/", nil)
+			resp, err := DebugTransport{Upstream: upstream}.RoundTrip(req)
+			if tt.wantBody == "" {
+				if err == nil {
+					_ = resp.Body.Close()
+					t.Fatal("expected the round trip to fail")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				if string(body) != tt.wantBody {
+					t.Errorf("body = %q, want %q", body, tt.wantBody)
+				}
+			}
+			if out := buf.String()[seen:]; tt.wantLog != "" && !strings.Contains(out, tt.wantLog) {
+				t.Errorf("log missing %q:\n%s", tt.wantLog, out)
+			}
+		})
+	}
+}
+
+func TestCertDetails(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotAfter: now.Add(48 * time.Hour)}
+	cert.Subject.CommonName, cert.Issuer.CommonName = "api.internal", "Corp CA"
+	want := "subject CN=api.internal, issuer CN=Corp CA, expires 2026-01-03 (2 days)"
+	if got := certDetails(cert, now); got != want {
+		t.Errorf("certDetails() = %q, want %q", got, want)
+	}
+	if got := certDetails(cert, now.Add(72*time.Hour)); !strings.Contains(got, "expired 2026-01-03") {
+		t.Errorf("certDetails() for an expired certificate = %q", got)
+	}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
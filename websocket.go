@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WebSocket opcodes (RFC 6455, section 5.2).
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// Direction arrows used in frame log entries.
+const (
+	wsClientToServer = "→"
+	wsServerToClient = "←"
+)
+
+// isWebSocketUpgrade reports whether resp completes a WebSocket handshake.
+func isWebSocketUpgrade(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusSwitchingProtocols &&
+		strings.EqualFold(resp.Header.Get("Upgrade"), "websocket")
+}
+
+// wsOpcodeName returns a human-readable name for a frame opcode.
+func wsOpcodeName(op byte) string {
+	switch op {
+	case wsOpContinuation:
+		return "continuation"
+	case wsOpText:
+		return "text"
+	case wsOpBinary:
+		return "binary"
+	case wsOpClose:
+		return "close"
+	case wsOpPing:
+		return "ping"
+	case wsOpPong:
+		return "pong"
+	default:
+		return "opcode " + strconv.Itoa(int(op))
+	}
+}
+
+// wsMessage is a complete data message (reassembled from fragments) or a control frame.
+type wsMessage struct {
+	Opcode     byte
+	Data       []byte // unmasked payload, capped at maxLogBodySize
+	Size       int64  // full payload length
+	Compressed bool   // RSV1 set: payload uses permessage-deflate and is shown as-is
+}
+
+// wsParser incrementally decodes WebSocket frames from one direction of a connection.
+// Data frames are reassembled into messages; control frames are reported immediately.
+type wsParser struct {
+	pending   []byte // header bytes not yet complete
+	inPayload bool
+	fin       bool
+	opcode    byte
+	masked    bool
+	mask      [4]byte
+	remaining int64
+	offset    int64 // position within the current frame payload, for unmasking
+	control   wsMessage
+	message   wsMessage
+	onMessage func(wsMessage)
+}
+
+// Write feeds raw connection bytes to the parser. It never fails; malformed input
+// simply stops producing messages.
+func (p *wsParser) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if !p.inPayload {
+			p.pending = append(p.pending, b...)
+			b = nil
+			consumed, ok := p.parseHeader()
+			if !ok {
+				break
+			}
+			b = p.pending[consumed:]
+			p.pending = nil
+			if p.remaining == 0 {
+				p.endFrame()
+			}
+			continue
+		}
+		chunk := b
+		if int64(len(chunk)) > p.remaining {
+			chunk = chunk[:p.remaining]
+		}
+		p.appendPayload(chunk)
+		b = b[len(chunk):]
+		p.remaining -= int64(len(chunk))
+		if p.remaining == 0 {
+			p.endFrame()
+		}
+	}
+	return n, nil
+}
+
+// parseHeader decodes a frame header from p.pending and returns the number of bytes it used.
+func (p *wsParser) parseHeader() (int, bool) {
+	h := p.pending
+	if len(h) < 2 {
+		return 0, false
+	}
+	need := 2
+	length := int64(h[1] & 0x7F)
+	switch length {
+	case 126:
+		need += 2
+	case 127:
+		need += 8
+	}
+	masked := h[1]&0x80 != 0
+	if masked {
+		need += 4
+	}
+	if len(h) < need {
+		return 0, false
+	}
+	pos := 2
+	switch length {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(h[pos:]))
+		pos += 2
+	case 127:
+		length = int64(binary.BigEndian.Uint64(h[pos:]) & (1<<63 - 1))
+		pos += 8
+	}
+	if masked {
+		copy(p.mask[:], h[pos:pos+4])
+	}
+
+	p.fin = h[0]&0x80 != 0
+	p.opcode = h[0] & 0x0F
+	p.masked = masked
+	p.remaining = length
+	p.offset = 0
+	p.inPayload = true
+
+	rsv1 := h[0]&0x40 != 0
+	switch {
+	case p.opcode >= wsOpClose:
+		p.control = wsMessage{Opcode: p.opcode, Size: length}
+	case p.opcode == wsOpContinuation:
+		p.message.Size += length
+	default:
+		p.message = wsMessage{Opcode: p.opcode, Size: length, Compressed: rsv1}
+	}
+	return need, true
+}
+
+func (p *wsParser) appendPayload(chunk []byte) {
+	target := &p.message
+	if p.opcode >= wsOpClose {
+		target = &p.control
+	}
+	room := maxLogBodySize - len(target.Data)
+	for i, c := range chunk {
+		if i >= room {
+			break
+		}
+		if p.masked {
+			c ^= p.mask[(p.offset+int64(i))%4]
+		}
+		target.Data = append(target.Data, c)
+	}
+	p.offset += int64(len(chunk))
+}
+
+func (p *wsParser) endFrame() {
+	p.inPayload = false
+	if p.opcode >= wsOpClose {
+		p.onMessage(p.control)
+		p.control = wsMessage{}
+		return
+	}
+	if p.fin {
+		p.onMessage(p.message)
+		p.message = wsMessage{}
+	}
+}
+
+// wsConn wraps the upgraded upstream connection returned by the transport. Bytes read
+// flow server → client and bytes written flow client → server; both directions are
+// parsed into frames and logged without altering the traffic.
+type wsConn struct {
+	rwc      io.ReadWriteCloser
+	parent   int64
+	redact   *redactor
+	visible  bool
+	seq      atomic.Int64
+	received atomic.Int64
+	fromSrv  *wsParser
+	toSrv    *wsParser
+	once     sync.Once
+	onClose  func(frames, received int64)
+}
+
+func newWSConn(rwc io.ReadWriteCloser, parent int64, redact *redactor, visible bool, onClose func(frames, received int64)) *wsConn {
+	c := &wsConn{rwc: rwc, parent: parent, redact: redact, visible: visible, onClose: onClose}
+	c.fromSrv = &wsParser{onMessage: func(m wsMessage) { c.log(wsServerToClient, m) }}
+	c.toSrv = &wsParser{onMessage: func(m wsMessage) { c.log(wsClientToServer, m) }}
+	return c
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	n, err := c.rwc.Read(p)
+	if n > 0 {
+		c.received.Add(int64(n))
+		_, _ = c.fromSrv.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	n, err := c.rwc.Write(p)
+	if n > 0 {
+		_, _ = c.toSrv.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *wsConn) Close() error {
+	err := c.rwc.Close()
+	c.once.Do(func() { c.onClose(c.seq.Load(), c.received.Load()) })
+	return err
+}
+
+func (c *wsConn) log(dir string, m wsMessage) {
+	seq := c.seq.Add(1)
+	if !c.visible || !*logResponses {
+		return
+	}
+	if m.Opcode == wsOpText && !m.Compressed {
+		m.Data = c.redact.JSON(m.Data)
+	}
+	if *logFormat == formatJSONL {
+		writeJSONLFrame(c.parent, seq, dir, m)
+		return
+	}
+	logWSMessage(c.parent, seq, dir, m)
+}
+
+// logWSMessage prints a frame as a numbered sub-entry of its parent exchange.
+func logWSMessage(parent, seq int64, dir string, m wsMessage) {
+	line := wrapColor(fmt.Sprintf("--- WS %d.%d %s %s (%d bytes) ---", parent, seq, dir, wsOpcodeName(m.Opcode), m.Size), colorEventMarker)
+	logEntry(parent, fmt.Sprintf("%s %s\n%s\n\n", coloredTime(time.Now(), colorEventMarker), line, formatWSPayload(m)))
+}
+
+// formatWSPayload renders a message payload for the text log.
+func formatWSPayload(m wsMessage) string {
+	switch {
+	case m.Compressed:
+		return wrapColor(fmt.Sprintf("[compressed payload: %d bytes]", m.Size), colorNull)
+	case int64(len(m.Data)) < m.Size:
+		return wrapColor(fmt.Sprintf("[payload too large to display: %d bytes]", m.Size), colorNull)
+	case m.Opcode == wsOpClose:
+		code, reason := wsCloseDetails(m.Data)
+		if code == 0 {
+			return wrapColor("(no status)", colorNull)
+		}
+		return wrapColor(strconv.Itoa(code), colorNumber) + " " + reason
+	case m.Opcode == wsOpBinary:
+		return wrapColor(fmt.Sprintf("[binary payload: %d bytes]", m.Size), colorNull)
+	case json.Valid(m.Data):
+		return highlightJSON(m.Data)
+	default:
+		return string(m.Data)
+	}
+}
+
+// wsCloseDetails extracts the status code and reason from a close frame payload.
+func wsCloseDetails(data []byte) (int, string) {
+	if len(data) < 2 {
+		return 0, ""
+	}
+	return int(binary.BigEndian.Uint16(data)), string(data[2:])
+}
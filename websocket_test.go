@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsFrame builds a single WebSocket frame, masking the payload when mask is non-nil.
+func wsFrame(fin bool, opcode byte, payload []byte, mask []byte) []byte {
+	var b bytes.Buffer
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	b.WriteByte(first)
+	maskBit := byte(0)
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		b.WriteByte(maskBit | byte(len(payload)))
+	default:
+		b.WriteByte(maskBit | 126)
+		b.WriteByte(byte(len(payload) >> 8))
+		b.WriteByte(byte(len(payload)))
+	}
+	if mask != nil {
+		b.Write(mask)
+		for i, c := range payload {
+			b.WriteByte(c ^ mask[i%4])
+		}
+		return b.Bytes()
+	}
+	b.Write(payload)
+	return b.Bytes()
+}
+
+func TestWSParser(t *testing.T) {
+	mask := []byte{1, 2, 3, 4}
+	long := strings.Repeat("x", 300)
+	tests := []struct {
+		name  string
+		input []byte
+		split int // feed the input in chunks of this size; 0 means all at once
+		want  []wsMessage
+	}{
+		{
+			name:  "unmasked text",
+			input: wsFrame(true, wsOpText, []byte("hello"), nil),
+			want:  []wsMessage{{Opcode: wsOpText, Data: []byte("hello"), Size: 5}},
+		},
+		{
+			name:  "masked text split byte by byte",
+			input: wsFrame(true, wsOpText, []byte(`{"a":1}`), mask),
+			split: 1,
+			want:  []wsMessage{{Opcode: wsOpText, Data: []byte(`{"a":1}`), Size: 7}},
+		},
+		{
+			name:  "extended length",
+			input: wsFrame(true, wsOpBinary, []byte(long), nil),
+			split: 7,
+			want:  []wsMessage{{Opcode: wsOpBinary, Data: []byte(long), Size: 300}},
+		},
+		{
+			name: "fragmented message with interleaved ping",
+			input: bytes.Join([][]byte{
+				wsFrame(false, wsOpText, []byte("hel"), mask),
+				wsFrame(true, wsOpPing, nil, mask),
+				wsFrame(true, wsOpContinuation, []byte("lo"), mask),
+			}, nil),
+			want: []wsMessage{
+				{Opcode: wsOpPing, Size: 0},
+				{Opcode: wsOpText, Data: []byte("hello"), Size: 5},
+			},
+		},
+		{
+			name:  "close frame",
+			input: wsFrame(true, wsOpClose, []byte{0x03, 0xE8, 'b', 'y', 'e'}, nil),
+			want:  []wsMessage{{Opcode: wsOpClose, Data: []byte{0x03, 0xE8, 'b', 'y', 'e'}, Size: 5}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []wsMessage
+			p := &wsParser{onMessage: func(m wsMessage) { got = append(got, m) }}
+			if tt.split == 0 {
+				_, _ = p.Write(tt.input)
+			} else {
+				for i := 0; i < len(tt.input); i += tt.split {
+					_, _ = p.Write(tt.input[i:min(i+tt.split, len(tt.input))])
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d messages %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i := range got {
+				if got[i].Opcode != tt.want[i].Opcode || got[i].Size != tt.want[i].Size || !bytes.Equal(got[i].Data, tt.want[i].Data) {
+					t.Errorf("message %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWSCloseDetails(t *testing.T) {
+	code, reason := wsCloseDetails([]byte{0x03, 0xE8, 'o', 'k'})
+	if code != 1000 || reason != "ok" {
+		t.Errorf("got %d %q, want 1000 \"ok\"", code, reason)
+	}
+	if code, _ := wsCloseDetails(nil); code != 0 {
+		t.Errorf("empty payload code = %d, want 0", code)
+	}
+}
+
+func TestProxyLogsWebSocketFrames(t *testing.T) {
+	originalNoColor := *noColor
+	*noColor = true
+	defer func() { *noColor = originalNoColor }()
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// Echo server: completes the handshake, then echoes one frame back unmasked.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = brw.Flush()
+
+		var got []wsMessage
+		p := &wsParser{onMessage: func(m wsMessage) { got = append(got, m) }}
+		chunk := make([]byte, 512)
+		for len(got) == 0 {
+			n, err := brw.Read(chunk)
+			if err != nil {
+				return
+			}
+			_, _ = p.Write(chunk[:n])
+		}
+		_, _ = conn.Write(wsFrame(true, wsOpText, got[0].Data, nil))
+		_, _ = io.Copy(io.Discard, conn)
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	proxy := httptest.NewServer(&httputil.ReverseProxy{
+		Transport: DebugTransport{},
+		Rewrite:   func(pr *httputil.ProxyRequest) { pr.SetURL(target) },
+	})
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, _ = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+
+	_, _ = conn.Write(wsFrame(true, wsOpText, []byte(`{"msg":"hi"}`), []byte{9, 8, 7, 6}))
+	echo := make([]byte, 2+len(`{"msg":"hi"}`))
+	if _, err := io.ReadFull(br, echo); err != nil {
+		t.Fatal(err)
+	}
+	if string(echo[2:]) != `{"msg":"hi"}` {
+		t.Errorf("echo payload = %q", echo[2:])
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for strings.Count(buf.String(), "--- WS ") < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "[websocket]") {
+		t.Errorf("handshake not logged: %q", out)
+	}
+	if !strings.Contains(out, ".1 "+wsClientToServer+" text") || !strings.Contains(out, ".2 "+wsServerToClient+" text") {
+		t.Errorf("frames not logged in both directions: %q", out)
+	}
+	if !strings.Contains(out, `"msg": "hi"`) {
+		t.Errorf("JSON text frame not highlighted: %q", out)
+	}
+
+	// Let the exchange complete before the deferred flag restores run.
+	_ = conn.Close()
+	for !strings.Contains(buf.String(), "CLOSED") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
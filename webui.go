@@ -0,0 +1,219 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//go:embed webui.html
+var webUIPage []byte
+
+// webUI keeps the most recent exchanges in a ring buffer and serves them to the browser UI
+// on the -admin listener. Open pages are told about new exchanges via Server-Sent Events.
+// A nil *webUI records nothing.
+type webUI struct {
+	mu   sync.Mutex
+	ring []*exchange
+	next int // slot for the next exchange
+	full bool
+	subs map[chan uiSummary]struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// uiSummary is one row of the exchange list.
+type uiSummary struct {
+	ID         int64     `json:"id"`
+	Start      time.Time `json:"start"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Error      string    `json:"error,omitempty"` // error class when the upstream was unreachable
+	Size       int64     `json:"size"`
+	DurationMs float64   `json:"duration_ms"`
+	Labels     []string  `json:"labels,omitempty"`
+}
+
+// uiDetail adds the highlighted headers and bodies, rendered as HTML.
+type uiDetail struct {
+	uiSummary
+	URL             string `json:"url"`
+	RequestHeaders  string `json:"request_headers"`
+	RequestBody     string `json:"request_body"`
+	ResponseHeaders string `json:"response_headers,omitempty"`
+	ResponseBody    string `json:"response_body,omitempty"`
+	ErrorMessage    string `json:"error_message,omitempty"`
+}
+
+func newWebUI(size int) *webUI {
+	return &webUI{
+		ring:   make([]*exchange, size),
+		subs:   make(map[chan uiSummary]struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+// Register adds the UI page and its API to mux.
+func (u *webUI) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /{$}", u.serveIndex)
+	mux.HandleFunc("GET /api/exchanges", u.serveList)
+	mux.HandleFunc("GET /api/exchanges/{id}", u.serveDetail)
+	mux.HandleFunc("GET /api/events", u.serveEvents)
+}
+
+// Add stores a finished exchange, evicting the oldest one once the buffer is full,
+// and notifies open pages. Slow pages miss notifications rather than stalling the proxy.
+func (u *webUI) Add(ex *exchange) {
+	if u == nil {
+		return
+	}
+	summary := newUISummary(ex)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.ring[u.next] = ex
+	u.next = (u.next + 1) % len(u.ring)
+	u.full = u.full || u.next == 0
+	for ch := range u.subs {
+		select {
+		case ch <- summary:
+		default:
+		}
+	}
+}
+
+// Close ends open event streams so the admin server can shut down.
+func (u *webUI) Close() {
+	u.closeOnce.Do(func() { close(u.closed) })
+}
+
+// exchanges returns the buffered exchanges, oldest first.
+func (u *webUI) exchanges() []*exchange {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.full {
+		return append([]*exchange(nil), u.ring[:u.next]...)
+	}
+	return append(append([]*exchange(nil), u.ring[u.next:]...), u.ring[:u.next]...)
+}
+
+func (u *webUI) lookup(id int64) *exchange {
+	for _, ex := range u.exchanges() {
+		if ex.ID == id {
+			return ex
+		}
+	}
+	return nil
+}
+
+func (u *webUI) serveIndex(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(webUIPage)
+}
+
+func (u *webUI) serveList(w http.ResponseWriter, _ *http.Request) {
+	list := u.exchanges()
+	summaries := make([]uiSummary, 0, len(list))
+	for _, ex := range list {
+		summaries = append(summaries, newUISummary(ex))
+	}
+	writeUIJSON(w, summaries)
+}
+
+func (u *webUI) serveDetail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid exchange id", http.StatusBadRequest)
+		return
+	}
+	ex := u.lookup(id)
+	if ex == nil {
+		http.Error(w, fmt.Sprintf("exchange %d is no longer buffered", id), http.StatusNotFound)
+		return
+	}
+	writeUIJSON(w, newUIDetail(ex))
+}
+
+// serveEvents streams the summary of every new exchange as a Server-Sent Event.
+func (u *webUI) serveEvents(w http.ResponseWriter, r *http.Request) {
+	ch := make(chan uiSummary, 64)
+	u.mu.Lock()
+	u.subs[ch] = struct{}{}
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		delete(u.subs, ch)
+		u.mu.Unlock()
+	}()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	for {
+		select {
+		case s := <-ch:
+			data, _ := json.Marshal(s)
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-u.closed:
+			return
+		}
+	}
+}
+
+func writeUIJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newUISummary(ex *exchange) uiSummary {
+	s := uiSummary{
+		ID:         ex.ID,
+		Start:      ex.Start,
+		Method:     ex.Request.Method,
+		Host:       ex.Request.URL.Host,
+		Path:       ex.Request.URL.RequestURI(),
+		Size:       ex.RespSize,
+		DurationMs: millis(ex.Duration()),
+		Labels:     ex.Labels,
+	}
+	switch {
+	case ex.Response != nil:
+		s.Status = ex.Response.StatusCode
+	case ex.Err != nil:
+		s.Error, s.Status = classifyError(ex.Err)
+	}
+	return s
+}
+
+func newUIDetail(ex *exchange) uiDetail {
+	r := ex.render(htmlPainter, false)
+	d := uiDetail{
+		uiSummary:       newUISummary(ex),
+		URL:             ex.Request.URL.String(),
+		RequestHeaders:  r.RequestHeaders,
+		RequestBody:     r.RequestBody,
+		ResponseHeaders: r.ResponseHeaders,
+		ResponseBody:    r.ResponseBody,
+	}
+	if ex.Err != nil {
+		d.ErrorMessage = ex.Err.Error()
+	}
+	return d
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWebUIRingBuffer(t *testing.T) {
+	u := newWebUI(3)
+	for id := int64(1); id <= 5; id++ {
+		u.Add(&exchange{ID: id, Request: httptest.NewRequest(http.MethodGet, "/", nil), Response: &http.Response{StatusCode: 200}})
+	}
+	var ids []int64
+	for _, ex := range u.exchanges() {
+		ids = append(ids, ex.ID)
+	}
+	if len(ids) != 3 || ids[0] != 3 || ids[2] != 5 {
+		t.Errorf("buffered ids = %v, want [3 4 5]", ids)
+	}
+	if u.lookup(2) != nil || u.lookup(4) == nil {
+		t.Error("lookup does not match the buffer contents")
+	}
+
+	var nilUI *webUI
+	nilUI.Add(&exchange{}) // must not panic
+}
+
+func TestNewUISummary(t *testing.T) {
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodPost, "http://api.test/orders?page=2", nil)
+	tests := []struct {
+		name       string
+		ex         *exchange
+		wantStatus int
+		wantError  string
+	}{
+		{name: "response", ex: &exchange{Request: req, Response: &http.Response{StatusCode: 201}}, wantStatus: 201},
+		{name: "refused", ex: &exchange{Request: req, Err: syscall.ECONNREFUSED}, wantStatus: 502, wantError: "connection refused"},
+		{name: "other", ex: &exchange{Request: req, Err: errors.New("boom")}, wantStatus: 502, wantError: "upstream error"},
+	}
+	for _, tt := range tests {
+		tt.ex.Start, tt.ex.End = start, start.Add(1500*time.Microsecond)
+		s := newUISummary(tt.ex)
+		if s.Status != tt.wantStatus || s.Error != tt.wantError {
+			t.Errorf("%s: status, error = %d, %q; want %d, %q", tt.name, s.Status, s.Error, tt.wantStatus, tt.wantError)
+		}
+		if s.Host != "api.test" || s.Path != "/orders?page=2" || s.DurationMs != 1.5 {
+			t.Errorf("%s: summary = %+v", tt.name, s)
+		}
+	}
+}
+
+func TestWebUIEndpoints(t *testing.T) {
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"name":"<Ada>"}`)
+	}))
+	defer origin.Close()
+	target, _ := url.Parse(origin.URL)
+
+	ui := newWebUI(10)
+	transport := DebugTransport{UI: ui}
+	proxy := httptest.NewServer(&httputil.ReverseProxy{
+		Transport:    transport,
+		Rewrite:      func(pr *httputil.ProxyRequest) { pr.SetURL(target) },
+		ErrorHandler: transport.ErrorHandler,
+	})
+	defer proxy.Close()
+	mux := http.NewServeMux()
+	ui.Register(mux)
+	admin := httptest.NewServer(mux)
+	defer admin.Close()
+	defer ui.Close()
+
+	events, err := http.Get(admin.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = events.Body.Close() }()
+	if ct := events.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("events Content-Type = %q", ct)
+	}
+
+	resp, err := http.Get(proxy.URL + "/users/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	line, err := bufio.NewReader(events.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pushed uiSummary
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &pushed); err != nil {
+		t.Fatalf("event %q: %v", line, err)
+	}
+	if pushed.Path != "/users/1" || pushed.Status != 200 {
+		t.Errorf("pushed summary = %+v", pushed)
+	}
+
+	var list []uiSummary
+	getJSON(t, admin.URL+"/api/exchanges", http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != pushed.ID {
+		t.Fatalf("list = %+v", list)
+	}
+
+	var d uiDetail
+	getJSON(t, admin.URL+"/api/exchanges/"+strconv.FormatInt(pushed.ID, 10), http.StatusOK, &d)
+	for _, want := range []string{`<span class="c35">GET</span>`, `<span class="c36">/users/1</span>`} {
+		if !strings.Contains(d.RequestHeaders, want) {
+			t.Errorf("request headers missing %q: %s", want, d.RequestHeaders)
+		}
+	}
+	if !strings.Contains(d.ResponseHeaders, `<span class="c32">200 OK</span>`) {
+		t.Errorf("response headers = %s", d.ResponseHeaders)
+	}
+	if !strings.Contains(d.ResponseBody, `<span class="c32">&#34;&lt;Ada&gt;&#34;</span>`) {
+		t.Errorf("response body = %s", d.ResponseBody)
+	}
+
+	getJSON(t, admin.URL+"/api/exchanges/999999", http.StatusNotFound, nil)
+
+	page, err := http.Get(admin.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(page.Body)
+	_ = page.Body.Close()
+	if !strings.Contains(string(body), "EventSource('api/events')") {
+		t.Error("index page not served")
+	}
+}
+
+func getJSON(t *testing.T, rawURL string, wantStatus int, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("GET %s = %d, want %d", rawURL, resp.StatusCode, wantStatus)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}